@@ -108,3 +108,59 @@ func Download(fn, bucketName string) error {
 	log.Info().Str("FileName", fileInfo.Name).Int64("Size", fileInfo.ContentLength).Str("ID", fileInfo.ID).Msg("downloaded file from backblaze")
 	return nil
 }
+
+// Exists returns true if a file named `fn` is stored in the bucket
+func Exists(fn, bucketName string) bool {
+	bucket, err := openBucket(bucketName)
+	if err != nil {
+		return false
+	}
+
+	resp, err := bucket.ListFileNamesWithPrefix(fn, 1, fn, "")
+	if err != nil {
+		log.Error().Err(err).Str("FileName", fn).Str("BucketName", bucketName).Msg("list files in backblaze failed")
+		return false
+	}
+
+	return len(resp.Files) > 0 && resp.Files[0].Name == fn
+}
+
+// Hide hides the file named `fn` so it is no longer found by Download.
+// Previous versions of the file are kept by backblaze.
+func Hide(fn, bucketName string) error {
+	bucket, err := openBucket(bucketName)
+	if err != nil {
+		return err
+	}
+
+	if _, err := bucket.HideFile(fn); err != nil {
+		log.Error().Err(err).Str("FileName", fn).Str("BucketName", bucketName).Msg("hide file in backblaze failed")
+		return err
+	}
+
+	log.Info().Str("FileName", fn).Str("BucketName", bucketName).Msg("hid file in backblaze")
+	return nil
+}
+
+func openBucket(bucketName string) (*backblaze.Bucket, error) {
+	b2, err := backblaze.NewB2(backblaze.Credentials{
+		KeyID:          viper.GetString("backblaze.application_id"),
+		ApplicationKey: viper.GetString("backblaze.application_key"),
+	})
+	if err != nil {
+		log.Error().Str("OriginalError", err.Error()).Str("BucketName", bucketName).Msg("authorize backblaze failed")
+		return nil, err
+	}
+
+	bucket, err := b2.Bucket(bucketName)
+	if err != nil {
+		log.Error().Str("OriginalError", err.Error()).Str("BucketName", bucketName).Msg("lookup bucket failed")
+		return nil, err
+	}
+	if bucket == nil {
+		log.Error().Str("BucketName", bucketName).Msg("bucket does not exist")
+		return nil, errors.New("bucket not found")
+	}
+
+	return bucket, nil
+}
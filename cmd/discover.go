@@ -0,0 +1,82 @@
+/*
+Copyright 2022
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/penny-vault/import-tickers/common"
+	"github.com/penny-vault/import-tickers/figi"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var discoverMaxPages int
+var discoverOutput string
+
+func init() {
+	openFigiCmd.AddCommand(discoverCmd)
+	discoverCmd.Flags().StringSlice("security-type", figi.DefaultDiscoverSecurityTypes, "openfigi securityType2 values to discover")
+	viper.BindPFlag("openfigi.discover_security_types", discoverCmd.Flags().Lookup("security-type"))
+	discoverCmd.Flags().IntVar(&discoverMaxPages, "max-pages", 50, "maximum number of pages of 100 listings to read per security type; 0 means no limit")
+	discoverCmd.Flags().StringVar(&discoverOutput, "output", "", "save the missing listings as JSON to this file")
+}
+
+var discoverCmd = &cobra.Command{
+	Use:   "discover",
+	Short: "Discover US-listed equities and funds in OpenFIGI that are missing from tickers.parquet",
+	Run: func(cmd *cobra.Command, args []string) {
+		universe := common.ReadAssetsFromParquet(viper.GetString("parquet_file"))
+
+		listings, err := figi.Discover(cmd.Context(), figi.DiscoverSecurityTypes(), discoverMaxPages)
+		if err != nil {
+			log.Error().Err(err).Int("NumListings", len(listings)).Msg("openfigi discovery failed")
+			if cmd.Context().Err() != nil {
+				os.Exit(common.EXIT_CODE_INTERRUPTED)
+			}
+			os.Exit(1)
+		}
+
+		missing := figi.MissingFromUniverse(listings, universe)
+		log.Info().Int("NumDiscovered", len(listings)).Int("NumAssets", len(universe)).Int("NumMissing", len(missing)).Msg("cross-checked openfigi listings with asset universe")
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "TICKER\tNAME\tSECURITY TYPE\tSECURITY TYPE 2\tCOMPOSITE FIGI")
+		for _, listing := range missing {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", listing.Ticker, listing.Name, listing.SecurityType, listing.SecurityType2, listing.CompositeFIGI)
+		}
+		tw.Flush()
+
+		if discoverOutput == "" {
+			return
+		}
+
+		data, err := json.MarshalIndent(missing, "", "  ")
+		if err != nil {
+			log.Error().Err(err).Msg("could not marshal missing listings to JSON")
+			os.Exit(1)
+		}
+		if err := os.WriteFile(discoverOutput, data, 0644); err != nil {
+			log.Error().Err(err).Str("FileName", discoverOutput).Msg("could not write missing listings")
+			os.Exit(1)
+		}
+		log.Info().Str("FileName", discoverOutput).Msg("wrote missing listings")
+	},
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2022
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/penny-vault/import-tickers/common"
+	"github.com/penny-vault/import-tickers/figi"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var pruneNegative bool
+
+func init() {
+	openFigiCmd.AddCommand(figiCacheCmd)
+	figiCacheCmd.AddCommand(figiCacheInspectCmd)
+	figiCacheCmd.AddCommand(figiCachePruneCmd)
+	figiCacheCmd.AddCommand(figiCacheWarmCmd)
+	figiCachePruneCmd.Flags().BoolVar(&pruneNegative, "negative", false, "also remove unexpired entries for queries that matched nothing")
+}
+
+// loadFigiCache opens the openfigi cache or exits if it is disabled
+func loadFigiCache() *figi.Cache {
+	cache := figi.LoadCache()
+	if cache == nil {
+		log.Error().Msg("openfigi cache is disabled; set --openfigi-cache-file")
+		os.Exit(1)
+	}
+	return cache
+}
+
+var figiCacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspect, prune and warm the local cache of openfigi mapping responses",
+}
+
+var figiCacheInspectCmd = &cobra.Command{
+	Use:   "inspect [id value]",
+	Short: "Print cache statistics and the cached responses for the given tickers or identifiers",
+	Run: func(cmd *cobra.Command, args []string) {
+		cache := loadFigiCache()
+		stats := cache.Stats()
+		log.Info().
+			Str("FileName", viper.GetString("openfigi.cache_file")).
+			Int("Entries", stats.Entries).
+			Int("Positive", stats.Positive).
+			Int("Negative", stats.Negative).
+			Int("Expired", stats.Expired).
+			Dur("PositiveTTL", cache.PositiveTTL).
+			Dur("NegativeTTL", cache.NegativeTTL).
+			Msg("openfigi cache")
+
+		if len(args) == 0 {
+			return
+		}
+
+		values := make(map[string]bool, len(args))
+		for _, arg := range args {
+			values[strings.ToUpper(arg)] = true
+		}
+
+		prefs := figi.LoadPreferences()
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		defer tw.Flush()
+		fmt.Fprintln(tw, "ID TYPE\tID VALUE\tEXCH\tCOMPOSITE FIGI\tRESULT\tFETCHED\tEXPIRED")
+		for _, entry := range cache.Entries() {
+			if !values[strings.ToUpper(entry.IdValue)] {
+				continue
+			}
+			result := fmt.Sprintf("%d listings", len(entry.Data))
+			compositeFigi := ""
+			if best, _ := prefs.Choose(entry.Data); best != nil {
+				compositeFigi = best.CompositeFIGI
+			}
+			if len(entry.Data) == 0 {
+				result = entry.Warning
+			}
+			fetched := time.Unix(entry.Fetched, 0).Format(time.RFC3339)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%t\n", entry.IdType, entry.IdValue, entry.ExchangeCode, compositeFigi, result, fetched, cache.Expired(entry))
+		}
+	},
+}
+
+var figiCachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove expired entries from the openfigi cache",
+	Run: func(cmd *cobra.Command, args []string) {
+		cache := loadFigiCache()
+		removed := cache.Prune(pruneNegative)
+		log.Info().Int("NumRemoved", removed).Int("NumRemaining", cache.Stats().Entries).Msg("pruned openfigi cache")
+		if err := cache.Save(); err != nil {
+			os.Exit(1)
+		}
+	},
+}
+
+var figiCacheWarmCmd = &cobra.Command{
+	Use:   "warm [ticker]",
+	Short: "Query openfigi for the given tickers or for assets in tickers.parquet that need a lookup and cache the responses",
+	Run: func(cmd *cobra.Command, args []string) {
+		loadFigiCache()
+
+		var assets []*common.Asset
+		if len(args) == 0 {
+			for _, asset := range common.ReadAssetsFromParquet(viper.GetString("parquet_file")) {
+				if figi.NeedsLookup(asset) {
+					assets = append(assets, asset)
+				}
+			}
+		} else {
+			assets = make([]*common.Asset, len(args))
+			for ii, ticker := range args {
+				assets[ii] = &common.Asset{
+					Ticker: ticker,
+				}
+			}
+		}
+
+		log.Info().Int("NumAssets", len(assets)).Msg("warming openfigi cache")
+		result, err := figi.LookupFigi(cmd.Context(), assets, figi.RateLimit())
+		if err != nil {
+			log.Error().Err(err).Msg("invalid openfigi configuration")
+			os.Exit(1)
+		}
+		log.Info().Int("NumMatched", len(result.Matches)).Int("NumUnmatched", len(result.Unmatched)).Msg("warmed openfigi cache")
+	},
+}
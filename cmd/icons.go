@@ -0,0 +1,92 @@
+/*
+Copyright 2022
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/penny-vault/import-tickers/backblaze"
+	"github.com/penny-vault/import-tickers/common"
+	"github.com/penny-vault/import-tickers/polygon"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var maxIcons int
+
+func init() {
+	rootCmd.AddCommand(iconsCmd)
+	iconsCmd.Flags().IntVar(&maxIcons, "max", 0, "maximum number of assets to download icons for")
+}
+
+var iconsCmd = &cobra.Command{
+	Use:   "icons [ticker]",
+	Short: "Download icons and logos for given tickers or for assets in tickers.parquet whose icon url changed",
+	Long: `Download icons and logos for given tickers or for assets in tickers.parquet
+whose icon url changed. Icon and logo hashes are saved to tickers.parquet, which
+is uploaded to backblaze so the next full import writes them to the database.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		iconsDir := viper.GetString("icons.dir")
+
+		if len(args) == 0 {
+			if viper.GetString("http.replay_dir") == "" {
+				backblaze.Download(viper.GetString("parquet_file"), viper.GetString("backblaze.bucket"))
+			}
+			assets := common.ReadAssetsFromParquet(viper.GetString("parquet_file"))
+			polygon.FetchIcons(cmd.Context(), assets, maxIcons)
+			common.SaveIcons(assets, iconsDir)
+			if err := common.SaveToParquet(assets, viper.GetString("parquet_file")); err != nil {
+				log.Error().Err(err).Msg("could not save icon hashes to parquet")
+				return
+			}
+			if !viper.GetBool("backblaze.skip_upload") {
+				uploadIcons(assets)
+				// a full import starts from the parquet file in backblaze
+				backblaze.Upload(viper.GetString("parquet_file"), viper.GetString("backblaze.bucket"), ".")
+			}
+		} else {
+			assets := make([]*common.Asset, len(args))
+			for ii, ticker := range args {
+				assets[ii] = &common.Asset{
+					Ticker: ticker,
+				}
+			}
+
+			polygon.EnrichDetail(cmd.Context(), assets, 0)
+			polygon.FetchIcons(cmd.Context(), assets, 0)
+			common.SaveIcons(assets, iconsDir)
+			for _, asset := range assets {
+				log.Info().
+					Str("Ticker", asset.Ticker).
+					Str("IconUrl", asset.IconUrl).
+					Str("IconHash", asset.IconHash).
+					Str("LogoUrl", asset.LogoUrl).
+					Str("LogoHash", asset.LogoHash).
+					Msg("saved icons")
+			}
+		}
+	},
+}
+
+// uploadIcons copies icons and logos saved during this run to the icons
+// directory of the backblaze bucket
+func uploadIcons(assets []*common.Asset) {
+	iconsDir := viper.GetString("icons.dir")
+	for _, name := range common.ChangedIconFiles(assets) {
+		backblaze.Upload(filepath.Join(iconsDir, name), viper.GetString("backblaze.bucket"), "icons")
+	}
+}
@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"os"
 	"time"
 
 	"github.com/penny-vault/import-tickers/common"
@@ -22,7 +23,6 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"golang.org/x/time/rate"
 )
 
 func init() {
@@ -30,9 +30,10 @@ func init() {
 }
 
 var openFigiCmd = &cobra.Command{
-	Use:   "openfigi [ticker]",
-	Short: "Lookup OpenFigi info for given ticker or for tickers with no figis in tickers.parquet",
-	Long:  `Lookup OpenFigi info for given ticker or for tickers with no figis in tickers.parquet`,
+	Use:     "openfigi [ticker]",
+	Aliases: []string{"figi"},
+	Short:   "Lookup OpenFigi info for given ticker or for tickers with no figis in tickers.parquet",
+	Long:    `Lookup OpenFigi info for given ticker or for tickers with no figis in tickers.parquet`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
 			// Search for FIGI's when the field is blank
@@ -41,7 +42,7 @@ var openFigiCmd = &cobra.Command{
 
 			currentTime := time.Now().Unix()
 
-			figi.Enrich(assets)
+			figi.Enrich(cmd.Context(), assets, 0)
 
 			for _, asset := range assets {
 				if asset.CompositeFigi == "" && asset.DelistingDate == "" {
@@ -62,10 +63,6 @@ var openFigiCmd = &cobra.Command{
 			common.SaveToParquet(finalAssets, viper.GetString("parquet_file"))
 		} else {
 			// lookup individual tickers
-			dur := (time.Second * 6) / 25
-			openFigiRate := rate.Every(dur)
-			rateLimit := rate.NewLimiter(openFigiRate, 10)
-
 			assets := make([]*common.Asset, len(args))
 			for ii, ticker := range args {
 				assets[ii] = &common.Asset{
@@ -73,11 +70,19 @@ var openFigiCmd = &cobra.Command{
 				}
 			}
 
-			figiResp := figi.LookupFigi(assets, rateLimit)
-			for _, asset := range figiResp {
-				assetFigi := figiResp[asset.Ticker]
+			result, err := figi.LookupFigi(cmd.Context(), assets, figi.RateLimit())
+			if err != nil {
+				log.Error().Err(err).Msg("invalid openfigi configuration")
+				os.Exit(1)
+			}
+			result.LogUnmatched()
+			for _, match := range result.Matches {
+				assetFigi := match.Figi
 				log.Info().
-					Str("Ticker", asset.Ticker).
+					Str("Ticker", match.Asset.Ticker).
+					Str("IdType", match.IdType).
+					Str("IdValue", match.IdValue).
+					Int("Candidates", match.Candidates).
 					Str("Name", assetFigi.Name).
 					Str("SecurityType", assetFigi.SecurityType).
 					Str("SecurityType2", assetFigi.SecurityType2).
@@ -37,7 +37,7 @@ var polygonCmd = &cobra.Command{
 			// Search for FIGI's when the field is blank
 			assets := common.ReadAssetsFromParquet(viper.GetString("parquet_file"))
 			log.Info().Int("NumAssets", len(assets)).Msg("fetching polygon details")
-			polygon.EnrichDetail(assets, maxPolyDetail)
+			polygon.EnrichDetail(cmd.Context(), assets, maxPolyDetail)
 			common.SaveToParquet(assets, viper.GetString("parquet_file"))
 		} else {
 			assets := make([]*common.Asset, len(args))
@@ -47,7 +47,7 @@ var polygonCmd = &cobra.Command{
 				}
 			}
 
-			polygon.EnrichDetail(assets, 0)
+			polygon.EnrichDetail(cmd.Context(), assets, 0)
 			for _, asset := range assets {
 				log.Info().
 					Str("Ticker", asset.Ticker).
@@ -16,14 +16,19 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/penny-vault/import-tickers/backblaze"
 	"github.com/penny-vault/import-tickers/common"
 	"github.com/penny-vault/import-tickers/figi"
 	"github.com/penny-vault/import-tickers/polygon"
+	"github.com/penny-vault/import-tickers/recorder"
 	"github.com/penny-vault/import-tickers/tiingo"
 	"github.com/penny-vault/import-tickers/yfinance"
 	"github.com/rs/zerolog"
@@ -33,7 +38,6 @@ import (
 )
 
 var cfgFile string
-var maxPolygonDetail int
 var maxPolygonDetailAge int64
 
 // rootCmd represents the base command when called without any subcommands
@@ -43,45 +47,44 @@ var rootCmd = &cobra.Command{
 	Long: `Download tradeable assets from polygon, tiingo, and Yahoo!
 and save to penny-vault database`,
 	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
 		nyc, err := time.LoadLocation("America/New_York")
 		if err != nil {
 			log.Error().Err(err).Msg("could not load timezone")
 			os.Exit(1)
 		}
 
+		runTime := time.Now()
+		dryRun := viper.GetBool("dry_run")
+		plan := common.NewPlan()
+		previous := make(map[string]*common.Asset)
+
 		log.Info().
+			Bool("DryRun", dryRun).
 			Bool("SaveDB", viper.GetBool("database.save")).
 			Bool("Backbalze.SkipUpload", viper.GetBool("backblaze.skip_upload")).
 			Str("TickerDB", viper.GetString("parquet_file")).
 			Str("Blacklist", viper.GetString("blacklist_fn")).
 			Msg("loading tickers")
 
-		backblaze.Download(viper.GetString("parquet_file"), viper.GetString("backblaze.bucket"))
-
-		// Fetch base list of assets
-		log.Info().Msg("fetching assets from polygon")
-		polygonAssets, err := polygon.FetchAssets(25)
-		if err != nil {
-			log.Error().Msg("exiting due to error downloading polygon assets")
-			os.Exit(common.EXIT_CODE_POLYGON)
-		}
-
-		if len(polygonAssets) < viper.GetInt("polygon.min_assets") {
-			log.Error().Int("NumAssets", len(polygonAssets)).Int("MinRequired", viper.GetInt("polygon.min_assets")).Msg("not enough polygon assets were downloaded - exiting")
-			os.Exit(common.EXIT_CODE_ASSET_COUNT_OUT_OF_RANGE)
+		// when replaying a previous run use the local parquet file as-is
+		if viper.GetString("http.replay_dir") == "" {
+			backblaze.Download(viper.GetString("parquet_file"), viper.GetString("backblaze.bucket"))
+			if viper.GetString("ticker_changes_file") != "" {
+				backblaze.Download(viper.GetString("ticker_changes_file"), viper.GetString("backblaze.bucket"))
+			}
+			if checkpointFn := viper.GetString("checkpoint_file"); checkpointFn != "" && backblaze.Exists(checkpointFn, viper.GetString("backblaze.bucket")) {
+				backblaze.Download(checkpointFn, viper.GetString("backblaze.bucket"))
+			}
 		}
 
-		// Fetch MutualFund tickers from tiingo
-		tiingoAssets := tiingo.FetchAssets()
-
-		if len(tiingoAssets) < viper.GetInt("tiingo.min_assets") {
-			log.Error().Int("NumAssets", len(tiingoAssets)).Int("MinRequired", viper.GetInt("tiingo.min_assets")).Msg("not enough tiingo assets were downloaded - exiting")
-			os.Exit(common.EXIT_CODE_ASSET_COUNT_OUT_OF_RANGE)
+		tickerChanges := []*common.TickerChange{}
+		if viper.GetString("ticker_changes_file") != "" {
+			tickerChanges = common.ReadTickerChangesFromParquet(viper.GetString("ticker_changes_file"))
 		}
 
-		// Merge polygon and tiingo lists
-		mergedAssets, _, _ := common.MergeAssetList(polygonAssets, tiingoAssets)
-		log.Info().Int("Num", len(mergedAssets)).Msg("polygon + tiingo")
+		// Fetch base list of assets from each configured source
+		mergedAssets := fetchUniverse(ctx, plan)
 
 		// Add tickers from file
 		staticAssetsFn := viper.GetString("static_assets_fn")
@@ -91,6 +94,9 @@ and save to penny-vault database`,
 			mergedAssets, _, _ = common.MergeAssetList(mergedAssets, tomlAssets)
 		}
 
+		// assets delisted by this run
+		delisted := make([]*common.Asset, 0)
+
 		// Load from parquet
 		parquetDb := viper.GetString("parquet_file")
 		if parquetDb != "" {
@@ -99,6 +105,7 @@ and save to penny-vault database`,
 
 			// remove delisted assets
 			parquetAssets = common.RemoveDelistedAssets(parquetAssets)
+			previous = common.SnapshotAssets(parquetAssets)
 
 			var first []*common.Asset
 			var second []*common.Asset
@@ -106,16 +113,48 @@ and save to penny-vault database`,
 
 			log.Info().Int("InParquetOnly", len(first)).Int("NewlyDownloaded", len(second)).Int("Total", len(mergedAssets)).Msg("merge with parquet")
 
-			// mark items only in first as delisted
+			// look up the ticker history of new assets so renames can be
+			// detected even when the old asset has no composite figi
+			if viper.GetBool("polygon.ticker_events") && len(second) > 0 {
+				log.Info().Int("NumAssets", len(second)).Msg("fetching ticker events for new assets")
+				tickerChanges = common.MergeTickerChanges(tickerChanges, polygon.FetchTickerChanges(ctx, second, viper.GetInt("polygon.ticker_events_max")))
+			}
+
+			// assets that changed tickers show up in both lists with the same
+			// composite figi; link them instead of treating them as unrelated
+			renames := common.DetectRenames(first, second, tickerChanges)
+			tickerChanges = common.MergeTickerChanges(tickerChanges, common.TickerChangesFromRenames(renames, time.Now().In(nyc).Format("2006-01-02")))
+			renamedTickers := make(map[string]bool, len(renames))
+			for _, rename := range renames {
+				renamedTickers[rename.NewTicker] = true
+			}
+
+			added := make([]*common.Asset, 0, len(second))
+			for _, asset := range second {
+				if !renamedTickers[asset.Ticker] {
+					added = append(added, asset)
+				}
+			}
+
+			plan.AddNew(added)
+			plan.AddRenamed(renames)
+
+			// mark items only in first as delisted; the actual delisting date
+			// is looked up from polygon once the removal safety valve passed
 			for _, asset := range first {
-				asset.DelistingDate = time.Now().In(nyc).Format("2006-01-02")
+				delistingDate := time.Now().In(nyc).Format("2006-01-02")
+				asset.RecordChange("DelistingDate", asset.DelistingDate, delistingDate, "import-tickers")
+				asset.DelistingDate = delistingDate
 			}
+			delisted = append(delisted, first...)
 
 			// mark items only in second as updated and set listing date if it's empty
 			for _, asset := range second {
 				asset.LastUpdated = time.Now().In(nyc).Unix()
 				if asset.ListingDate == "" {
-					asset.ListingDate = time.Now().In(nyc).Format("2006-01-02")
+					listingDate := time.Now().In(nyc).Format("2006-01-02")
+					asset.RecordChange("ListingDate", asset.ListingDate, listingDate, "import-tickers")
+					asset.ListingDate = listingDate
 				}
 			}
 		}
@@ -124,27 +163,25 @@ and save to penny-vault database`,
 		blacklistFn := viper.GetString("blacklist_fn")
 		if blacklistFn != "" {
 			blacklisted := common.ReadAssetsFromToml(blacklistFn)
+			beforeBlacklist := mergedAssets
 			mergedAssets = common.RemoveAssets(mergedAssets, blacklisted)
+			plan.AddBlacklisted(common.SubtractAssets(beforeBlacklist, mergedAssets))
 		}
 
-		// Enrich with call to Polygon Asset Details
-		log.Info().Msg("fetching asset details from polygon")
-		polygon.EnrichDetail(mergedAssets, 5)
-
-		// Search for FIGI's when the field is blank
-		log.Info().Msg("fetching missing figi's")
-		figi.Enrich(mergedAssets)
+		// Pick up enrichment completed by an interrupted run
+		if checkpointFn := viper.GetString("checkpoint_file"); checkpointFn != "" {
+			common.ApplyCheckpoint(mergedAssets, checkpointFn)
+		}
 
-		// cleanup assets
-		beforeCleanCnt := len(mergedAssets)
-		mergedAssets = common.CleanAssets(mergedAssets)
-		afterCleanCnt := len(mergedAssets)
-		log.Debug().Int("RemovedAssetCount", beforeCleanCnt-afterCleanCnt).Msg("Removed assets with no FIGI or Asset Type")
-		common.TrimWhiteSpace(mergedAssets)
+		// Run each configured enrichment stage (polygon detail, figi, yahoo, ...)
+		mergedAssets, held := enrichAssets(ctx, mergedAssets)
+		stopIfInterrupted(ctx, mergedAssets)
 
-		// Enrich with call to Yahoo Finance
-		log.Info().Msg("fetching data from yahoo!")
-		yfinance.Enrich(mergedAssets, 5)
+		// assets held back by a stage (e.g. malformed identifiers) keep the
+		// version saved by the previous run or are left out; either way
+		// they aren't delisted
+		mergedAssets = restoreHeld(mergedAssets, held, previous)
+		plan.AddHeld(held)
 
 		// Prune multi-case assets
 		beforeFilterCnt := len(mergedAssets)
@@ -158,12 +195,14 @@ and save to penny-vault database`,
 
 		// deduplicate figi's that have multiple active assets
 		// associated with them
+		beforeDedup := mergedAssets
 		mergedAssets = common.DeduplicateCompositeFigi(mergedAssets)
+		plan.AddDeduplicated(beforeDedup, mergedAssets)
 
 		if viper.GetString("database.url") != "" {
 			// Compare against assets currently in DB to find what is getting removed
 			assetsDb := common.ActiveAssetsFromDatabase()
-			removedAssets := common.SubtractAssets(assetsDb, mergedAssets)
+			removedAssets := withoutHeld(common.SubtractAssets(assetsDb, mergedAssets), held)
 			log.Info().Int("NumAssetsRemoved", len(removedAssets)).Msg("found delisted assets")
 
 			// Check how many assets are marked for removal
@@ -176,42 +215,249 @@ and save to penny-vault database`,
 				}
 			}
 			if numRemoved > viper.GetInt("max_removed_count") {
-				log.Error().Int("MaxAllowed", viper.GetInt("max_removed_count")).Int("Actual", numRemoved).Msg("too many assets removed - bailing")
-				os.Exit(common.EXIT_CODE_ASSET_COUNT_OUT_OF_RANGE)
+				if !dryRun {
+					log.Error().Int("MaxAllowed", viper.GetInt("max_removed_count")).Int("Actual", numRemoved).Msg("too many assets removed - bailing")
+					os.Exit(common.EXIT_CODE_ASSET_COUNT_OUT_OF_RANGE)
+				}
+				log.Warn().Int("MaxAllowed", viper.GetInt("max_removed_count")).Int("Actual", numRemoved).Msg("too many assets removed - a real run would bail")
 			}
 
 			// mark removed assets so statistics are correctly calculated
 			for _, asset := range removedAssets {
-				asset.DelistingDate = time.Now().In(nyc).Format("2006-01-02")
+				delistingDate := time.Now().In(nyc).Format("2006-01-02")
+				asset.RecordChange("DelistingDate", asset.DelistingDate, delistingDate, "database")
+				asset.DelistingDate = delistingDate
 				asset.LastUpdated = time.Now().In(nyc).Unix()
 				asset.Updated = true
-				asset.UpdateReason = "asset delisted"
 				mergedAssets = append(mergedAssets, asset)
 			}
+			delisted = append(delisted, removedAssets...)
+		}
 
+		// use the actual delisting date from polygon when it is known
+		if viper.GetBool("polygon.inactive") && len(delisted) > 0 {
+			log.Info().Int("NumAssets", len(delisted)).Msg("fetching delisting dates from polygon")
+			polygon.EnrichDelisted(ctx, delisted)
+			stopIfInterrupted(ctx, mergedAssets)
+		}
+
+		if viper.GetString("database.url") != "" {
 			common.LogSummary(mergedAssets)
 
-			if viper.GetBool("database.save") {
+			if viper.GetBool("database.save") && !dryRun {
 				if err = common.SaveToDatabase(mergedAssets); err != nil {
 					os.Exit(common.EXIT_CODE_DATABASE_ERROR)
 				}
+				if err = common.SaveTickerChangesToDatabase(tickerChanges); err != nil {
+					os.Exit(common.EXIT_CODE_DATABASE_ERROR)
+				}
 			}
 		}
 
+		plan.Finalize(previous, mergedAssets)
+		plan.LogSummary()
+
+		if dryRun {
+			plan.PrintTable(os.Stdout)
+			if planFn := viper.GetString("plan_file"); planFn != "" {
+				if err := plan.WriteJSON(planFn); err != nil {
+					os.Exit(1)
+				}
+			}
+			return
+		}
+
+		changesFn := ""
 		if viper.GetString("parquet_file") != "" {
 			common.SaveToParquet(mergedAssets, viper.GetString("parquet_file"))
+
+			changesFn = common.ChangesParquetFileName(viper.GetString("parquet_file"), runTime)
+			if err := common.SaveChangesToParquet(mergedAssets, changesFn); err != nil {
+				changesFn = ""
+			}
+		}
+
+		tickerChangesFn := viper.GetString("ticker_changes_file")
+		if tickerChangesFn != "" {
+			if err := common.SaveTickerChangesToParquet(tickerChanges, tickerChangesFn); err != nil {
+				tickerChangesFn = ""
+			}
 		}
 
 		if !viper.GetBool("backblaze.skip_upload") {
 			backblaze.Upload(viper.GetString("parquet_file"), viper.GetString("backblaze.bucket"), ".")
+			if changesFn != "" {
+				backblaze.Upload(changesFn, viper.GetString("backblaze.bucket"), ".")
+			}
+			if tickerChangesFn != "" {
+				backblaze.Upload(tickerChangesFn, viper.GetString("backblaze.bucket"), ".")
+			}
+			uploadIcons(mergedAssets)
+		}
+
+		// enrichment from an interrupted run has now been saved
+		if checkpointFn := viper.GetString("checkpoint_file"); checkpointFn != "" {
+			common.RemoveCheckpoint(checkpointFn)
+			if !viper.GetBool("backblaze.skip_upload") && backblaze.Exists(checkpointFn, viper.GetString("backblaze.bucket")) {
+				backblaze.Hide(checkpointFn, viper.GetString("backblaze.bucket"))
+			}
 		}
 	},
 }
 
+// stopIfInterrupted exits if the run was cancelled by SIGINT or SIGTERM. The
+// assets enriched so far are saved to the checkpoint file (and backblaze) so
+// the next run picks up where this one stopped.
+func stopIfInterrupted(ctx context.Context, assets []*common.Asset) {
+	if ctx.Err() == nil {
+		return
+	}
+
+	log.Warn().Msg("import interrupted - exiting")
+
+	checkpointFn := viper.GetString("checkpoint_file")
+	if checkpointFn != "" && !viper.GetBool("dry_run") {
+		if err := common.SaveCheckpoint(assets, checkpointFn); err == nil && !viper.GetBool("backblaze.skip_upload") {
+			backblaze.Upload(checkpointFn, viper.GetString("backblaze.bucket"), ".")
+		}
+	}
+
+	os.Exit(common.EXIT_CODE_INTERRUPTED)
+}
+
+// fetchUniverse downloads assets from each source listed in the config file
+// and merges them in the configured order. The process exits if a source
+// fails or returns fewer assets than its configured minimum, or when `ctx` is
+// cancelled. Source reports are added to `plan`.
+func fetchUniverse(ctx context.Context, plan *common.Plan) []*common.Asset {
+	sourceConfigs, err := common.ConfiguredSources()
+	if err != nil {
+		log.Error().Err(err).Strs("Registered", common.RegisteredSources()).Msg("invalid sources configuration")
+		os.Exit(1)
+	}
+
+	mergedAssets := []*common.Asset{}
+	for _, sourceConfig := range sourceConfigs {
+		source, _ := common.LookupSource(sourceConfig.Name)
+		subLog := log.With().Str("Source", source.Name()).Logger()
+
+		subLog.Info().Msg("fetching assets")
+		assets, err := source.FetchAssets(ctx)
+		if ctx.Err() != nil {
+			subLog.Warn().Msg("import interrupted while fetching assets - exiting")
+			os.Exit(common.EXIT_CODE_INTERRUPTED)
+		}
+		if err != nil {
+			subLog.Error().Err(err).Msg("exiting due to error downloading assets")
+			os.Exit(common.EXIT_CODE_SOURCE)
+		}
+
+		minAssets := sourceConfig.MinAssetsFor(source)
+		if len(assets) < minAssets {
+			subLog.Error().Int("NumAssets", len(assets)).Int("MinRequired", minAssets).Msg("not enough assets were downloaded - exiting")
+			os.Exit(common.EXIT_CODE_ASSET_COUNT_OUT_OF_RANGE)
+		}
+
+		if reporter, ok := source.(common.SourceReporter); ok {
+			plan.AddSourceReport(source.Name(), reporter.Report())
+		}
+
+		mergedAssets, _, _ = common.MergeAssetList(mergedAssets, assets)
+		subLog.Info().Int("NumAssets", len(assets)).Int("Total", len(mergedAssets)).Msg("merged source assets")
+	}
+
+	return mergedAssets
+}
+
+// enrichAssets runs the enrichment stages configured for the selected run
+// profile in order and returns the enriched assets along with the assets the
+// stages held back from the save. Remaining stages are skipped once `ctx` is
+// cancelled. The process exits if a stage fails.
+func enrichAssets(ctx context.Context, assets []*common.Asset) ([]*common.Asset, []*common.HeldAsset) {
+	profile := viper.GetString("profile")
+	enricherConfigs, err := common.ConfiguredEnrichers(profile)
+	if err != nil {
+		log.Error().Err(err).Str("Profile", profile).Strs("Registered", common.RegisteredEnrichers()).Msg("invalid enrichers configuration")
+		os.Exit(1)
+	}
+
+	held := make([]*common.HeldAsset, 0)
+	for _, enricherConfig := range enricherConfigs {
+		if ctx.Err() != nil {
+			break
+		}
+		enricher, _ := common.LookupEnricher(enricherConfig.Name)
+		log.Info().Str("Stage", enricher.Name()).Int("Max", enricherConfig.Max).Int("NumAssets", len(assets)).Msg("running enrichment stage")
+		assets = enricher.Enrich(ctx, assets, enricherConfig.Max)
+		if failing, ok := enricher.(common.EnricherError); ok && failing.Err() != nil {
+			log.Error().Err(failing.Err()).Str("Stage", enricher.Name()).Msg("enrichment stage failed")
+			os.Exit(1)
+		}
+		if holder, ok := enricher.(common.AssetHolder); ok {
+			held = append(held, holder.Held()...)
+		}
+	}
+
+	return assets, held
+}
+
+// restoreHeld adds the previously saved version of each held asset that
+// should be kept back to `assets`. The restored assets are not marked as
+// updated. Held assets that weren't saved before are left out.
+func restoreHeld(assets []*common.Asset, held []*common.HeldAsset, previous map[string]*common.Asset) []*common.Asset {
+	for _, item := range held {
+		if !item.Keep {
+			continue
+		}
+		saved, ok := previous[item.Asset.Ticker]
+		if !ok {
+			continue
+		}
+		restored := *saved
+		restored.Updated = false
+		restored.Changes = nil
+		assets = append(assets, &restored)
+	}
+	return assets
+}
+
+// withoutHeld removes held assets from `assets`
+func withoutHeld(assets []*common.Asset, held []*common.HeldAsset) []*common.Asset {
+	heldTickers := make(map[string]bool, len(held))
+	for _, item := range held {
+		heldTickers[item.Asset.Ticker] = true
+	}
+
+	remaining := make([]*common.Asset, 0, len(assets))
+	for _, asset := range assets {
+		if !heldTickers[asset.Ticker] {
+			remaining = append(remaining, asset)
+		}
+	}
+	return remaining
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// SIGINT and SIGTERM cancel the context passed to commands; a second signal
+// terminates the process immediately.
 func Execute() {
-	err := rootCmd.Execute()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// done is closed before stop so a normal exit isn't reported as a signal
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			log.Warn().Msg("received signal - finishing in-flight requests; signal again to exit immediately")
+			stop()
+		case <-done:
+		}
+	}()
+
+	err := rootCmd.ExecuteContext(ctx)
 	if err != nil {
 		os.Exit(1)
 	}
@@ -220,6 +466,7 @@ func Execute() {
 func init() {
 	cobra.OnInitialize(initConfig)
 	cobra.OnInitialize(initLog)
+	cobra.OnInitialize(initHTTP)
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is import-tickers.toml)")
 	rootCmd.PersistentFlags().Bool("log-json", false, "print logs as json to stderr")
@@ -231,9 +478,37 @@ func init() {
 	viper.BindPFlag("database.url", rootCmd.PersistentFlags().Lookup("database-url"))
 	rootCmd.PersistentFlags().Bool("database-save", false, "save assets to database")
 	viper.BindPFlag("database.save", rootCmd.PersistentFlags().Lookup("database-save"))
+	rootCmd.PersistentFlags().Bool("database-history", true, "record each version of an asset in the assets_history table (see sql/assets_history.sql)")
+	viper.BindPFlag("database.history", rootCmd.PersistentFlags().Lookup("database-history"))
+
+	rootCmd.PersistentFlags().String("record-dir", "", "save every request made to data providers (with credentials scrubbed) in the specified directory")
+	viper.BindPFlag("http.record_dir", rootCmd.PersistentFlags().Lookup("record-dir"))
+	rootCmd.PersistentFlags().String("replay-dir", "", "answer requests to data providers from recordings in the specified directory instead of the network")
+	viper.BindPFlag("http.replay_dir", rootCmd.PersistentFlags().Lookup("replay-dir"))
+
+	rootCmd.PersistentFlags().Int("workers", 4, "number of concurrent lookups made by each enrichment stage; override per provider with <provider>.workers")
+	viper.BindPFlag("workers", rootCmd.PersistentFlags().Lookup("workers"))
+
+	rootCmd.PersistentFlags().Int("retry-max-attempts", 4, "maximum number of attempts for each request to a data provider; override per provider with <provider>.max_attempts")
+	viper.BindPFlag("retry.max_attempts", rootCmd.PersistentFlags().Lookup("retry-max-attempts"))
+	rootCmd.PersistentFlags().Duration("retry-base-delay", 500*time.Millisecond, "delay before the first retry of a failed request; doubles with each attempt")
+	viper.BindPFlag("retry.base_delay", rootCmd.PersistentFlags().Lookup("retry-base-delay"))
+	rootCmd.PersistentFlags().Duration("retry-max-delay", 30*time.Second, "maximum delay between retries of a failed request")
+	viper.BindPFlag("retry.max_delay", rootCmd.PersistentFlags().Lookup("retry-max-delay"))
+	rootCmd.PersistentFlags().Duration("retry-max-retry-after", 5*time.Minute, "longest Retry-After requested by a server that is honored; longer waits fail the request")
+	viper.BindPFlag("retry.max_retry_after", rootCmd.PersistentFlags().Lookup("retry-max-retry-after"))
+
+	rootCmd.PersistentFlags().String("profile", "", "run profile selecting the enrichment stages in profiles.<name>.enrichers")
+	viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile"))
 
 	rootCmd.PersistentFlags().String("parquet-file", "tickers.parquet", "save results to parquet")
 	viper.BindPFlag("parquet_file", rootCmd.PersistentFlags().Lookup("parquet-file"))
+	rootCmd.PersistentFlags().String("icons-dir", "icons", "directory icons and logos are saved to by content address")
+	viper.BindPFlag("icons.dir", rootCmd.PersistentFlags().Lookup("icons-dir"))
+	rootCmd.PersistentFlags().String("checkpoint-file", "tickers-checkpoint.parquet", "save enrichment completed by an interrupted run to this file and resume from it on the next run")
+	viper.BindPFlag("checkpoint_file", rootCmd.PersistentFlags().Lookup("checkpoint-file"))
+	rootCmd.PersistentFlags().String("ticker-changes-file", "ticker_changes.parquet", "load and save ticker change history to parquet")
+	viper.BindPFlag("ticker_changes_file", rootCmd.PersistentFlags().Lookup("ticker-changes-file"))
 
 	rootCmd.PersistentFlags().Int("max-removed-count", 50, "maximum number of assets that can be removed per run; this is a safety feature in-case something goes wrong to prevent the database from getting hosed up")
 	viper.BindPFlag("max_removed_count", rootCmd.PersistentFlags().Lookup("max-removed-count"))
@@ -265,17 +540,57 @@ func init() {
 	viper.BindPFlag("polygon.rate_limit", rootCmd.PersistentFlags().Lookup("polygon-rate-limit"))
 	rootCmd.PersistentFlags().Int("polygon-min-assets", 4000, "minimum number of assets expected from polygon")
 	viper.BindPFlag("polygon.min_assets", rootCmd.PersistentFlags().Lookup("polygon-min-assets"))
+	rootCmd.PersistentFlags().Int("polygon-max-pages", 100, "maximum number of pages of assets to download from polygon; the import fails if more are available (0 means no limit)")
+	viper.BindPFlag("polygon.max_pages", rootCmd.PersistentFlags().Lookup("polygon-max-pages"))
+	rootCmd.PersistentFlags().String("polygon-checkpoint-file", "polygon-checkpoint.json", "save polygon download progress to this file so an interrupted download resumes")
+	viper.BindPFlag("polygon.checkpoint_file", rootCmd.PersistentFlags().Lookup("polygon-checkpoint-file"))
+	rootCmd.PersistentFlags().Duration("polygon-checkpoint-max-age", 6*time.Hour, "ignore polygon download checkpoints older than this")
+	viper.BindPFlag("polygon.checkpoint_max_age", rootCmd.PersistentFlags().Lookup("polygon-checkpoint-max-age"))
+	rootCmd.PersistentFlags().Bool("polygon-inactive", true, "look up the delisting date of removed assets in polygon's inactive tickers")
+	viper.BindPFlag("polygon.inactive", rootCmd.PersistentFlags().Lookup("polygon-inactive"))
+	rootCmd.PersistentFlags().Duration("polygon-inactive-lookback", polygon.DefaultInactiveLookback, "read polygon's inactive tickers delisted within this long before now")
+	viper.BindPFlag("polygon.inactive_lookback", rootCmd.PersistentFlags().Lookup("polygon-inactive-lookback"))
+	rootCmd.PersistentFlags().Bool("polygon-ticker-events", true, "fetch the ticker change history of new assets from polygon")
+	viper.BindPFlag("polygon.ticker_events", rootCmd.PersistentFlags().Lookup("polygon-ticker-events"))
+	rootCmd.PersistentFlags().Int("polygon-ticker-events-max", 100, "maximum number of new assets to fetch ticker events for; 0 means no limit")
+	viper.BindPFlag("polygon.ticker_events_max", rootCmd.PersistentFlags().Lookup("polygon-ticker-events-max"))
 
 	// tiingo
+	rootCmd.PersistentFlags().String("tiingo-token", "<not-set>", "tiingo API token; required by the tiingo enrichment stage")
+	viper.BindPFlag("tiingo.token", rootCmd.PersistentFlags().Lookup("tiingo-token"))
+	rootCmd.PersistentFlags().Int("tiingo-rate-limit", 60, "tiingo rate limit (items per minute)")
+	viper.BindPFlag("tiingo.rate_limit", rootCmd.PersistentFlags().Lookup("tiingo-rate-limit"))
+	rootCmd.PersistentFlags().String("tiingo-cache-dir", "tiingo-cache", "cache supported_tickers.zip in this directory and only download it again when it changes; empty disables the cache")
+	viper.BindPFlag("tiingo.cache_dir", rootCmd.PersistentFlags().Lookup("tiingo-cache-dir"))
 	rootCmd.PersistentFlags().Int("tiingo-min-assets", 5000, "minimum number of assets expected from tiingo")
 	viper.BindPFlag("tiingo.min_assets", rootCmd.PersistentFlags().Lookup("tiingo-min-assets"))
 
 	// openfigi
 	rootCmd.PersistentFlags().String("openfigi-apikey", "<not-set>", "openfigi API key token")
 	viper.BindPFlag("openfigi.apikey", rootCmd.PersistentFlags().Lookup("openfigi-apikey"))
+	rootCmd.PersistentFlags().String("openfigi-cache-file", "openfigi-cache.json", "cache openfigi mapping responses in this file; empty disables the cache")
+	viper.BindPFlag("openfigi.cache_file", rootCmd.PersistentFlags().Lookup("openfigi-cache-file"))
+	rootCmd.PersistentFlags().Duration("openfigi-cache-ttl", figi.DefaultCachePositiveTTL, "how long openfigi queries that matched are cached")
+	viper.BindPFlag("openfigi.cache_ttl", rootCmd.PersistentFlags().Lookup("openfigi-cache-ttl"))
+	rootCmd.PersistentFlags().Duration("openfigi-cache-negative-ttl", figi.DefaultCacheNegativeTTL, "how long openfigi queries that matched nothing are cached")
+	viper.BindPFlag("openfigi.cache_negative_ttl", rootCmd.PersistentFlags().Lookup("openfigi-cache-negative-ttl"))
+
+	// validation
+	rootCmd.PersistentFlags().String("validate-action", "quarantine", "what the validate stage does with assets that have malformed identifiers: reject or quarantine")
+	viper.BindPFlag("validate.action", rootCmd.PersistentFlags().Lookup("validate-action"))
+	rootCmd.PersistentFlags().String("quarantine-file", "tickers-quarantine.json", "save assets quarantined by the validate stage and their problems as JSON to this file")
+	viper.BindPFlag("validate.quarantine_file", rootCmd.PersistentFlags().Lookup("quarantine-file"))
 
 	// Local flags
-	rootCmd.Flags().IntVar(&maxPolygonDetail, "max-polygon-detail", 100, "maximum polygon detail to fetch")
+	rootCmd.Flags().Bool("dry-run", false, "run the import and print the resulting change plan without saving to parquet, database, or backblaze")
+	viper.BindPFlag("dry_run", rootCmd.Flags().Lookup("dry-run"))
+	rootCmd.Flags().String("plan-file", "", "save the dry-run change plan as JSON to the specified file")
+	viper.BindPFlag("plan_file", rootCmd.Flags().Lookup("plan-file"))
+
+	// the polygon stage is limited by max in [[enrichers]]; the flag is kept so
+	// existing invocations still parse
+	rootCmd.Flags().Int("max-polygon-detail", 100, "maximum polygon detail to fetch")
+	rootCmd.Flags().MarkDeprecated("max-polygon-detail", "set max on the polygon stage in [[enrichers]] instead")
 
 	rootCmd.Flags().Duration("max-age", 24*7*time.Hour, "maximum number of days stocks end date may be set too and still included")
 	viper.BindPFlag("max_age", rootCmd.Flags().Lookup("max-age"))
@@ -290,6 +605,45 @@ func initLog() {
 	}
 }
 
+// initHTTP configures the http client used by each data provider for
+// recording or replaying requests
+func initHTTP() {
+	recordDir := viper.GetString("http.record_dir")
+	replayDir := viper.GetString("http.replay_dir")
+	secrets := []string{viper.GetString("polygon.token"), viper.GetString("tiingo.token"), viper.GetString("openfigi.apikey")}
+
+	var transport http.RoundTripper
+	switch {
+	case recordDir != "" && replayDir != "":
+		log.Error().Msg("--record-dir and --replay-dir cannot be used together")
+		os.Exit(1)
+	case recordDir != "":
+		recordTransport, err := recorder.NewRecorder(recordDir, nil, secrets...)
+		if err != nil {
+			log.Error().Err(err).Str("Dir", recordDir).Msg("could not create record directory")
+			os.Exit(1)
+		}
+		log.Info().Str("Dir", recordDir).Msg("recording requests")
+		transport = recordTransport
+	case replayDir != "":
+		replayTransport, err := recorder.NewReplayer(replayDir, secrets...)
+		if err != nil {
+			log.Error().Err(err).Str("Dir", replayDir).Msg("could not open replay directory")
+			os.Exit(1)
+		}
+		log.Info().Str("Dir", replayDir).Msg("replaying recorded requests")
+		transport = replayTransport
+	default:
+		return
+	}
+
+	client := &http.Client{Transport: transport}
+	polygon.SetHTTPClient(client)
+	tiingo.SetHTTPClient(client)
+	figi.SetHTTPClient(client)
+	yfinance.SetHTTPClient(client)
+}
+
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	if cfgFile != "" {
@@ -0,0 +1,257 @@
+/*
+Copyright 2022
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/penny-vault/import-tickers/common"
+	"github.com/penny-vault/import-tickers/figi"
+	"github.com/penny-vault/import-tickers/polygon"
+	"github.com/penny-vault/import-tickers/tiingo"
+	"github.com/penny-vault/import-tickers/yfinance"
+	"github.com/spf13/pflag"
+)
+
+// replayDir holds the provider responses the import test is answered from.
+// They were captured with --record-dir from stand-in servers and are keyed
+// by request, so changing the requests an import makes requires recording
+// them again.
+var replayDir = filepath.Join("testdata", "replay")
+
+// importConfig is the config file for an import that writes its output to
+// `dir` and reads nothing but the recorded responses
+const importConfig = `
+parquet_file = "%[1]s/tickers.parquet"
+ticker_changes_file = "%[1]s/ticker_changes.parquet"
+checkpoint_file = "%[1]s/tickers-checkpoint.parquet"
+
+[database]
+url = ""
+
+[backblaze]
+skip_upload = true
+
+[display]
+hide_progress = true
+
+[retry]
+max_attempts = 1
+
+[polygon]
+token = "test-token"
+rate_limit = 60000
+checkpoint_file = "%[1]s/polygon-checkpoint.json"
+ticker_events = false
+inactive_lookback = "1000000h"
+
+[tiingo]
+cache_dir = ""
+
+[openfigi]
+cache_file = ""
+
+[validate]
+quarantine_file = "%[1]s/tickers-quarantine.json"
+
+[[sources]]
+name = "polygon"
+min_assets = 1
+
+[[sources]]
+name = "tiingo"
+min_assets = 1
+
+[[enrichers]]
+name = "polygon"
+
+[[enrichers]]
+name = "figi"
+
+[[enrichers]]
+name = "clean"
+
+[[enrichers]]
+name = "validate"
+`
+
+// writeImportScenario writes the config file and the assets saved by the
+// previous import to `dir` and returns the path of the config file. FB has
+// since been renamed to META and TWTR delisted.
+func writeImportScenario(t *testing.T, dir string) string {
+	t.Helper()
+
+	previous := []*common.Asset{
+		{Ticker: "AAPL", AssetType: common.CommonStock, CompositeFigi: "BBG000B9XRY4", ShareClassFigi: "BBG001S5N8V8", Name: "Apple", ListingDate: "1980-12-12", Source: "api.polygon.io"},
+		{Ticker: "FB", AssetType: common.CommonStock, CompositeFigi: "BBG000MM2P62", ShareClassFigi: "BBG001SQCQD4", Name: "Meta Platforms", ListingDate: "2012-05-18", CIK: "0001326801", Source: "api.polygon.io"},
+		{Ticker: "TWTR", AssetType: common.CommonStock, CompositeFigi: "BBG000H6HNW3", Name: "Twitter", ListingDate: "2013-11-07", Source: "api.polygon.io"},
+	}
+	if err := common.SaveToParquet(previous, filepath.Join(dir, "tickers.parquet")); err != nil {
+		t.Fatalf("could not write previous assets: %v", err)
+	}
+
+	fn := filepath.Join(dir, "import-tickers.toml")
+	if err := os.WriteFile(fn, []byte(fmt.Sprintf(importConfig, filepath.ToSlash(dir))), 0644); err != nil {
+		t.Fatalf("could not write config: %v", err)
+	}
+	return fn
+}
+
+// runImport runs the root command with `args`. Flags set by `args` are
+// restored to their defaults afterwards; viper isn't reset because the flag
+// bindings made in init would be lost.
+func runImport(t *testing.T, args ...string) {
+	t.Helper()
+
+	defer func() {
+		for _, flags := range []*pflag.FlagSet{rootCmd.Flags(), rootCmd.PersistentFlags()} {
+			flags.VisitAll(func(flag *pflag.Flag) {
+				if flag.Changed {
+					flag.Value.Set(flag.DefValue)
+					flag.Changed = false
+				}
+			})
+		}
+		polygon.SetHTTPClient(nil)
+		tiingo.SetHTTPClient(nil)
+		figi.SetHTTPClient(nil)
+		yfinance.SetHTTPClient(nil)
+		rootCmd.SetArgs(nil)
+	}()
+
+	rootCmd.SetArgs(args)
+	if err := rootCmd.ExecuteContext(context.Background()); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+}
+
+func TestImportReplay(t *testing.T) {
+	dir := t.TempDir()
+	configFn := writeImportScenario(t, dir)
+
+	runImport(t, "--config", configFn, "--replay-dir", replayDir)
+
+	// delisted assets aren't saved to the parquet file
+	assets := common.BuildAssetMap(common.ReadAssetsFromParquet(filepath.Join(dir, "tickers.parquet")))
+	if len(assets) != 4 {
+		t.Errorf("saved %d assets, want 4", len(assets))
+	}
+
+	tests := []struct {
+		ticker        string
+		compositeFigi string
+		assetType     common.AssetType
+	}{
+		{"AAPL", "BBG000B9XRY4", common.CommonStock},
+		{"META", "BBG000MM2P62", common.CommonStock},
+		{"MSFT", "BBG000BPH459", common.CommonStock},
+		{"VFIAX", "BBG000BHTMY2", common.MutualFund},
+	}
+
+	for _, tt := range tests {
+		asset, ok := assets[tt.ticker]
+		if !ok {
+			t.Errorf("%s is missing from the saved assets", tt.ticker)
+			continue
+		}
+		if asset.CompositeFigi != tt.compositeFigi || asset.AssetType != tt.assetType {
+			t.Errorf("%s = {%s %s}, want {%s %s}", tt.ticker, asset.CompositeFigi, asset.AssetType, tt.compositeFigi, tt.assetType)
+		}
+	}
+
+	// details come from polygon's ticker details
+	if aapl := assets["AAPL"]; aapl != nil && (aapl.Name != "Apple Inc." || aapl.SicDescription != "ELECTRONIC COMPUTERS") {
+		t.Errorf("AAPL details were not applied: name %q, sic description %q", aapl.Name, aapl.SicDescription)
+	}
+
+	// META keeps the history of FB
+	if meta := assets["META"]; meta != nil && (meta.PreviousTicker != "FB" || meta.ListingDate != "2012-05-18") {
+		t.Errorf("META previous ticker = %q, listing date = %q, want FB's", meta.PreviousTicker, meta.ListingDate)
+	}
+
+	// OpenFIGI found no identifier for ZZZQ so the clean stage dropped it
+	if _, ok := assets["ZZZQ"]; ok {
+		t.Error("ZZZQ has no composite figi and should not be saved")
+	}
+
+	changes := common.ReadTickerChangesFromParquet(filepath.Join(dir, "ticker_changes.parquet"))
+	if len(changes) != 1 || changes[0].OldTicker != "FB" || changes[0].NewTicker != "META" || changes[0].CompositeFigi != "BBG000MM2P62" {
+		t.Errorf("ticker changes = %+v, want FB -> META", changes)
+	}
+}
+
+func TestImportReplayDryRun(t *testing.T) {
+	dir := t.TempDir()
+	configFn := writeImportScenario(t, dir)
+	planFn := filepath.Join(dir, "plan.json")
+
+	runImport(t, "--config", configFn, "--replay-dir", replayDir, "--dry-run", "--plan-file", planFn)
+
+	data, err := os.ReadFile(planFn)
+	if err != nil {
+		t.Fatalf("plan was not written: %v", err)
+	}
+	plan := common.Plan{}
+	if err := json.Unmarshal(data, &plan); err != nil {
+		t.Fatalf("could not parse plan: %v", err)
+	}
+
+	tickers := func(entries []*common.PlanEntry) string {
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			names = append(names, entry.Ticker)
+		}
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+
+	// new listings are planned before the enrichment stages run, so ZZZQ is
+	// listed even though the clean stage drops it
+	if got := tickers(plan.New); got != "MSFT,VFIAX,ZZZQ" {
+		t.Errorf("new = %s, want MSFT,VFIAX,ZZZQ", got)
+	}
+	if got := tickers(plan.Renamed); got != "META" {
+		t.Errorf("renamed = %s, want META", got)
+	}
+	if got := tickers(plan.Delisted); got != "TWTR" {
+		t.Errorf("delisted = %s, want TWTR", got)
+	}
+	if got := tickers(plan.Deduplicated); got != "FB" {
+		t.Errorf("deduplicated = %s, want FB", got)
+	}
+
+	// the delisting date is polygon's, not the date of the run
+	for _, entry := range plan.Delisted {
+		if entry.Reason != "delisted 2022-11-08" {
+			t.Errorf("%s reason = %q, want delisted 2022-11-08", entry.Ticker, entry.Reason)
+		}
+	}
+
+	// a dry run doesn't save anything
+	if assets := common.ReadAssetsFromParquet(filepath.Join(dir, "tickers.parquet")); len(assets) != 3 {
+		t.Errorf("parquet file has %d assets after a dry run, want the 3 previous assets", len(assets))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ticker_changes.parquet")); !os.IsNotExist(err) {
+		t.Errorf("ticker changes were saved during a dry run: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2022
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/penny-vault/import-tickers/common"
+	"github.com/penny-vault/import-tickers/polygon"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var maxTickerEvents int
+
+func init() {
+	rootCmd.AddCommand(tickerChangesCmd)
+	tickerChangesCmd.Flags().IntVar(&maxTickerEvents, "max", 100, "maximum number of assets to fetch ticker events for when no tickers are given; 0 means no limit")
+}
+
+var tickerChangesCmd = &cobra.Command{
+	Use:   "ticker-changes [ticker]",
+	Short: "Fetch ticker change history from polygon for given tickers or for active assets in tickers.parquet",
+	Run: func(cmd *cobra.Command, args []string) {
+		var assets []*common.Asset
+		max := 0
+		if len(args) == 0 {
+			max = maxTickerEvents
+			assets = common.RemoveDelistedAssets(common.ReadAssetsFromParquet(viper.GetString("parquet_file")))
+		} else {
+			assets = make([]*common.Asset, len(args))
+			for ii, ticker := range args {
+				assets[ii] = &common.Asset{
+					Ticker: ticker,
+				}
+			}
+		}
+
+		log.Info().Int("NumAssets", len(assets)).Msg("fetching ticker events")
+		changes := polygon.FetchTickerChanges(cmd.Context(), assets, max)
+		for _, change := range changes {
+			log.Info().
+				Str("CompositeFigi", change.CompositeFigi).
+				Str("OldTicker", change.OldTicker).
+				Str("NewTicker", change.NewTicker).
+				Str("Date", change.Date).
+				Msg("ticker change")
+		}
+
+		fn := viper.GetString("ticker_changes_file")
+		if fn == "" {
+			return
+		}
+
+		changes = common.MergeTickerChanges(common.ReadTickerChangesFromParquet(fn), changes)
+		if err := common.SaveTickerChangesToParquet(changes, fn); err != nil {
+			os.Exit(1)
+		}
+	},
+}
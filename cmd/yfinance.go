@@ -15,7 +15,6 @@
 package cmd
 
 import (
-	"context"
 	"time"
 
 	"github.com/penny-vault/import-tickers/common"
@@ -45,7 +44,7 @@ var yfinanceCmd = &cobra.Command{
 
 			currentTime := time.Now().Unix()
 
-			yfinance.Enrich(assets, yfinanceLimit)
+			yfinance.Enrich(cmd.Context(), assets, yfinanceLimit)
 
 			for _, asset := range assets {
 				if asset.LastUpdated > currentTime {
@@ -71,7 +70,7 @@ var yfinanceCmd = &cobra.Command{
 			}
 
 			for _, asset := range assets {
-				rateLimit.Wait(context.Background())
+				rateLimit.Wait(cmd.Context())
 				yfinance.Download(asset)
 				log.Info().
 					Str("Ticker", asset.Ticker).
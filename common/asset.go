@@ -15,18 +15,16 @@
 package common
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"image"
 	"os"
+	"path/filepath"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	_ "image/jpeg"
-	_ "image/png"
-
 	"github.com/jackc/pgtype"
 	"github.com/jackc/pgx/v4"
 	"github.com/pelletier/go-toml"
@@ -52,61 +50,134 @@ const (
 	UnknownAsset AssetType = "Unknown"
 )
 
+// UnknownFigi is the placeholder stored in place of a composite figi that
+// couldn't be found
+const UnknownFigi = "UNKNOWN"
+
+// AssetTypes lists every supported asset type
+var AssetTypes = []AssetType{CommonStock, ETF, ETN, CEF, MutualFund, ADRC, FRED, UnknownAsset}
+
+// ParseAssetType returns the asset type named `value`; case is ignored
+func ParseAssetType(value string) (AssetType, error) {
+	for _, assetType := range AssetTypes {
+		if strings.EqualFold(string(assetType), strings.TrimSpace(value)) {
+			return assetType, nil
+		}
+	}
+	return "", fmt.Errorf("unknown asset type '%s'", value)
+}
+
+// FieldChange is a single change made to an asset field during an import
+type FieldChange struct {
+	Field     string `json:"field"`
+	Old       string `json:"old"`
+	New       string `json:"new"`
+	Source    string `json:"source"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// FieldChanges is the ordered list of changes made to an asset
+type FieldChanges []*FieldChange
+
+// fieldChangeRecord is the row format of the per-run changes parquet file
+type fieldChangeRecord struct {
+	Ticker        string `parquet:"name=ticker, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	CompositeFigi string `parquet:"name=composite_figi, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Field         string `parquet:"name=field, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Old           string `parquet:"name=old, type=BYTE_ARRAY, convertedtype=UTF8"`
+	New           string `parquet:"name=new, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Source        string `parquet:"name=source, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Timestamp     int64  `parquet:"name=timestamp, type=INT64"`
+}
+
 type tomlAssetContainer struct {
 	Assets []*Asset
 }
 
 type Asset struct {
-	Ticker               string    `json:"ticker" parquet:"name=ticker, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	Name                 string    `json:"Name" parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	Description          string    `json:"description" parquet:"name=description, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	PrimaryExchange      string    `json:"primary_exchange" toml:"primary_exchange" parquet:"name=primary_exchange, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	AssetType            AssetType `json:"asset_type" toml:"asset_type" parquet:"name=asset_type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	CompositeFigi        string    `json:"composite_figi" toml:"composite_figi" parquet:"name=composite_figi, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	ShareClassFigi       string    `json:"share_class_figi" toml:"share_class_figi" parquet:"name=share_class_figi, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	CUSIP                string    `json:"cusip" parquet:"name=cusip, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	ISIN                 string    `json:"isin" parquet:"name=isin, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	CIK                  string    `json:"cik" parquet:"name=cik, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	ListingDate          string    `json:"listing_date" toml:"listing_date" parquet:"name=listing_date, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	DelistingDate        string    `json:"delisting_date" toml:"delisting_date" parquet:"name=delisting_date, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	Industry             string    `json:"industry" parquet:"name=industry, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	Sector               string    `json:"sector" parquet:"name=sector, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	Icon                 []byte    `json:"icon"`
-	IconUrl              string    `json:"icon_url" toml:"icon_url" parquet:"name=icon_url, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	CorporateUrl         string    `json:"corporate_url" toml:"corporate_url" parquet:"name=corporate_url, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	HeadquartersLocation string    `json:"headquarters_location" toml:"headquarters_location" parquet:"name=headquarters_location, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	SimilarTickers       []string  `json:"similar_tickers" toml:"similar_tickers" parquet:"name=similar_tickers, type=MAP, convertedtype=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
-	PolygonDetailAge     int64     `json:"polygon_detail_age" parquet:"name=polygon_detail_age, type=INT64"`
-	FidelityCusip        bool      `parquet:"name=fidelity_cusip, type=BOOLEAN"`
-
-	Updated      bool
-	UpdateReason string
+	Ticker                      string    `json:"ticker" parquet:"name=ticker, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Name                        string    `json:"Name" parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Description                 string    `json:"description" parquet:"name=description, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	PrimaryExchange             string    `json:"primary_exchange" toml:"primary_exchange" parquet:"name=primary_exchange, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	AssetType                   AssetType `json:"asset_type" toml:"asset_type" parquet:"name=asset_type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	CompositeFigi               string    `json:"composite_figi" toml:"composite_figi" parquet:"name=composite_figi, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	ShareClassFigi              string    `json:"share_class_figi" toml:"share_class_figi" parquet:"name=share_class_figi, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	CUSIP                       string    `json:"cusip" parquet:"name=cusip, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	ISIN                        string    `json:"isin" parquet:"name=isin, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	CIK                         string    `json:"cik" parquet:"name=cik, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	ListingDate                 string    `json:"listing_date" toml:"listing_date" parquet:"name=listing_date, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	DelistingDate               string    `json:"delisting_date" toml:"delisting_date" parquet:"name=delisting_date, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Industry                    string    `json:"industry" parquet:"name=industry, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Sector                      string    `json:"sector" parquet:"name=sector, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Icon                        []byte    `json:"icon"`
+	Logo                        []byte    `json:"logo"`
+	IconUrl                     string    `json:"icon_url" toml:"icon_url" parquet:"name=icon_url, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	LogoUrl                     string    `json:"logo_url" toml:"logo_url" parquet:"name=logo_url, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	IconHash                    string    `json:"icon_hash" toml:"icon_hash" parquet:"name=icon_hash, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	LogoHash                    string    `json:"logo_hash" toml:"logo_hash" parquet:"name=logo_hash, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	CorporateUrl                string    `json:"corporate_url" toml:"corporate_url" parquet:"name=corporate_url, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	HeadquartersLocation        string    `json:"headquarters_location" toml:"headquarters_location" parquet:"name=headquarters_location, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	HeadquartersCity            string    `json:"headquarters_city" toml:"headquarters_city" parquet:"name=headquarters_city, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	HeadquartersState           string    `json:"headquarters_state" toml:"headquarters_state" parquet:"name=headquarters_state, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	HeadquartersPostalCode      string    `json:"headquarters_postal_code" toml:"headquarters_postal_code" parquet:"name=headquarters_postal_code, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	HeadquartersCountry         string    `json:"headquarters_country" toml:"headquarters_country" parquet:"name=headquarters_country, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	SimilarTickers              []string  `json:"similar_tickers" toml:"similar_tickers" parquet:"name=similar_tickers, type=MAP, convertedtype=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	PolygonDetailAge            int64     `json:"polygon_detail_age" parquet:"name=polygon_detail_age, type=INT64"`
+	FidelityCusip               bool      `parquet:"name=fidelity_cusip, type=BOOLEAN"`
+	PreviousTicker              string    `json:"previous_ticker" toml:"previous_ticker" parquet:"name=previous_ticker, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	MarketCap                   float64   `json:"market_cap" toml:"market_cap" parquet:"name=market_cap, type=DOUBLE"`
+	SicCode                     string    `json:"sic_code" toml:"sic_code" parquet:"name=sic_code, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	SicDescription              string    `json:"sic_description" toml:"sic_description" parquet:"name=sic_description, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	TotalEmployees              int64     `json:"total_employees" toml:"total_employees" parquet:"name=total_employees, type=INT64"`
+	PhoneNumber                 string    `json:"phone_number" toml:"phone_number" parquet:"name=phone_number, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Address                     string    `json:"address" toml:"address" parquet:"name=address, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	ShareClassSharesOutstanding int64     `json:"share_class_shares_outstanding" toml:"share_class_shares_outstanding" parquet:"name=share_class_shares_outstanding, type=INT64"`
+	WeightedSharesOutstanding   int64     `json:"weighted_shares_outstanding" toml:"weighted_shares_outstanding" parquet:"name=weighted_shares_outstanding, type=INT64"`
+
+	Updated bool
+	Changes FieldChanges
 
 	LastUpdated int64  `json:"last_updated" parquet:"name=last_update, type=INT64"`
 	Source      string `json:"source" parquet:"name=source, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
 }
 
 type assetTmp struct {
-	Ticker               string   `json:"ticker" parquet:"name=ticker, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	Name                 string   `json:"Name" parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	Description          string   `json:"description" parquet:"name=description, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	PrimaryExchange      string   `json:"primary_exchange" parquet:"name=primary_exchange, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	AssetType            string   `json:"asset_type" parquet:"name=asset_type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	CompositeFigi        string   `json:"composite_figi" parquet:"name=composite_figi, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	ShareClassFigi       string   `json:"share_class_figi" parquet:"name=share_class_figi, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	CUSIP                string   `json:"cusip" parquet:"name=cusip, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	ISIN                 string   `json:"isin" parquet:"name=isin, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	CIK                  string   `json:"cik" parquet:"name=cik, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	ListingDate          string   `json:"listing_date" parquet:"name=listing_date, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	DelistingDate        string   `json:"delisting_date" parquet:"name=delisting_date, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	Industry             string   `json:"industry" parquet:"name=industry, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	Sector               string   `json:"sector" parquet:"name=sector, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	IconUrl              string   `json:"icon_url" parquet:"name=icon_url, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	CorporateUrl         string   `json:"corporate_url" parquet:"name=corporate_url, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	HeadquartersLocation string   `json:"headquarters_location" parquet:"name=headquarters_location, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	SimilarTickers       []string `json:"similar_tickers" parquet:"name=similar_tickers, type=MAP, convertedtype=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
-	PolygonDetailAge     int64    `json:"polygon_detail_age" parquet:"name=polygon_detail_age, type=INT64"`
-	FidelityCusip        bool     `parquet:"name=fidelity_cusip, type=BOOLEAN"`
+	Ticker                      string   `json:"ticker" parquet:"name=ticker, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Name                        string   `json:"Name" parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Description                 string   `json:"description" parquet:"name=description, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	PrimaryExchange             string   `json:"primary_exchange" parquet:"name=primary_exchange, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	AssetType                   string   `json:"asset_type" parquet:"name=asset_type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	CompositeFigi               string   `json:"composite_figi" parquet:"name=composite_figi, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	ShareClassFigi              string   `json:"share_class_figi" parquet:"name=share_class_figi, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	CUSIP                       string   `json:"cusip" parquet:"name=cusip, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	ISIN                        string   `json:"isin" parquet:"name=isin, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	CIK                         string   `json:"cik" parquet:"name=cik, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	ListingDate                 string   `json:"listing_date" parquet:"name=listing_date, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	DelistingDate               string   `json:"delisting_date" parquet:"name=delisting_date, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Industry                    string   `json:"industry" parquet:"name=industry, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Sector                      string   `json:"sector" parquet:"name=sector, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	IconUrl                     string   `json:"icon_url" parquet:"name=icon_url, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	LogoUrl                     string   `json:"logo_url" parquet:"name=logo_url, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	IconHash                    string   `json:"icon_hash" parquet:"name=icon_hash, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	LogoHash                    string   `json:"logo_hash" parquet:"name=logo_hash, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	CorporateUrl                string   `json:"corporate_url" parquet:"name=corporate_url, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	HeadquartersLocation        string   `json:"headquarters_location" parquet:"name=headquarters_location, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	HeadquartersCity            string   `json:"headquarters_city" parquet:"name=headquarters_city, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	HeadquartersState           string   `json:"headquarters_state" parquet:"name=headquarters_state, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	HeadquartersPostalCode      string   `json:"headquarters_postal_code" parquet:"name=headquarters_postal_code, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	HeadquartersCountry         string   `json:"headquarters_country" parquet:"name=headquarters_country, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	SimilarTickers              []string `json:"similar_tickers" parquet:"name=similar_tickers, type=MAP, convertedtype=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	PolygonDetailAge            int64    `json:"polygon_detail_age" parquet:"name=polygon_detail_age, type=INT64"`
+	FidelityCusip               bool     `parquet:"name=fidelity_cusip, type=BOOLEAN"`
+	PreviousTicker              string   `json:"previous_ticker" parquet:"name=previous_ticker, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	MarketCap                   float64  `json:"market_cap" parquet:"name=market_cap, type=DOUBLE"`
+	SicCode                     string   `json:"sic_code" parquet:"name=sic_code, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	SicDescription              string   `json:"sic_description" parquet:"name=sic_description, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	TotalEmployees              int64    `json:"total_employees" parquet:"name=total_employees, type=INT64"`
+	PhoneNumber                 string   `json:"phone_number" parquet:"name=phone_number, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Address                     string   `json:"address" parquet:"name=address, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	ShareClassSharesOutstanding int64    `json:"share_class_shares_outstanding" parquet:"name=share_class_shares_outstanding, type=INT64"`
+	WeightedSharesOutstanding   int64    `json:"weighted_shares_outstanding" parquet:"name=weighted_shares_outstanding, type=INT64"`
 
 	Updated     bool
 	LastUpdated int64  `json:"last_updated" parquet:"name=last_update, type=INT64"`
@@ -135,9 +206,10 @@ func CleanAssets(assets []*Asset) []*Asset {
 
 // DeduplicateCompositeFigi de-dupes assets that belong to the same composite
 // figi. Dedup rules are as follows:
-//  1. Common stock is preferred to all other types
-//  2. Closed-end funds are preferred to mutual funds
-//  3. Most recent listed_utc is preferred
+//  1. Listed assets are preferred to delisted assets
+//  2. Common stock is preferred to all other types
+//  3. Closed-end funds are preferred to mutual funds
+//  4. Most recent listed_utc is preferred
 func DeduplicateCompositeFigi(assets []*Asset) []*Asset {
 	dedupAssets := make([]*Asset, 0, len(assets))
 
@@ -166,7 +238,12 @@ func DeduplicateCompositeFigi(assets []*Asset) []*Asset {
 	for k, v := range compositeMap {
 		sort.SliceStable(v, func(i, j int) bool {
 			a, b := v[i], v[j]
-			if a.AssetType == CommonStock && b.AssetType != CommonStock {
+			if a.DelistingDate == "" && b.DelistingDate != "" {
+				// renamed tickers leave a delisted asset with the same figi
+				return true
+			} else if b.DelistingDate == "" && a.DelistingDate != "" {
+				return false
+			} else if a.AssetType == CommonStock && b.AssetType != CommonStock {
 				// highest priority is common stock
 				return true
 			} else if b.AssetType == CommonStock && a.AssetType != CommonStock {
@@ -359,112 +436,117 @@ func MergeAsset(a *Asset, b *Asset) *Asset {
 		a.AssetType = b.AssetType
 	}
 
-	if b.CIK != "" && a.CIK != b.CIK {
-		a.UpdateReason = fmt.Sprintf("CIK changed '%s' to '%s'", a.CIK, b.CIK)
-		a.CIK = b.CIK
-		a.Updated = true
-		a.LastUpdated = time.Now().Unix()
-	}
-
-	if b.CUSIP != "" && a.CUSIP != b.CUSIP {
-		a.UpdateReason = fmt.Sprintf("CUSIP changed '%s' to '%s'", a.CUSIP, b.CUSIP)
-		a.CUSIP = b.CUSIP
-		a.Updated = true
-		a.LastUpdated = time.Now().Unix()
-	}
-
-	if b.CompositeFigi != "" && a.CompositeFigi != b.CompositeFigi {
-		a.UpdateReason = fmt.Sprintf("CompositeFigi changed '%s' to '%s'", a.CompositeFigi, b.CompositeFigi)
-		a.CompositeFigi = b.CompositeFigi
-		a.Updated = true
-		a.LastUpdated = time.Now().Unix()
-	}
-
-	if b.CorporateUrl != "" && a.CorporateUrl != b.CorporateUrl {
-		a.UpdateReason = fmt.Sprintf("CorporateUrl changed '%s' to '%s'", a.CorporateUrl, b.CorporateUrl)
-		a.CorporateUrl = b.CorporateUrl
-		a.Updated = true
-		a.LastUpdated = time.Now().Unix()
-	}
+	a.mergeField("CIK", &a.CIK, b.CIK, b.Source)
+	a.mergeField("CUSIP", &a.CUSIP, b.CUSIP, b.Source)
+	a.mergeField("CompositeFigi", &a.CompositeFigi, b.CompositeFigi, b.Source)
+	a.mergeField("CorporateUrl", &a.CorporateUrl, b.CorporateUrl, b.Source)
+	a.mergeField("DelistingDate", &a.DelistingDate, b.DelistingDate, b.Source)
+	a.mergeField("Description", &a.Description, b.Description, b.Source)
+	a.mergeField("HeadquartersLocation", &a.HeadquartersLocation, b.HeadquartersLocation, b.Source)
+	a.mergeField("HeadquartersCity", &a.HeadquartersCity, b.HeadquartersCity, b.Source)
+	a.mergeField("HeadquartersState", &a.HeadquartersState, b.HeadquartersState, b.Source)
+	a.mergeField("HeadquartersPostalCode", &a.HeadquartersPostalCode, b.HeadquartersPostalCode, b.Source)
+	a.mergeField("HeadquartersCountry", &a.HeadquartersCountry, b.HeadquartersCountry, b.Source)
+	a.mergeField("ISIN", &a.ISIN, b.ISIN, b.Source)
+	a.SetIconUrl(b.IconUrl, b.Source)
+	a.SetLogoUrl(b.LogoUrl, b.Source)
+	a.mergeField("IconHash", &a.IconHash, b.IconHash, b.Source)
+	a.mergeField("LogoHash", &a.LogoHash, b.LogoHash, b.Source)
+	a.mergeField("Industry", &a.Industry, b.Industry, b.Source)
+	a.mergeField("ListingDate", &a.ListingDate, b.ListingDate, b.Source)
+	a.mergeField("Name", &a.Name, b.Name, b.Source)
+	a.mergeField("PrimaryExchange", &a.PrimaryExchange, b.PrimaryExchange, b.Source)
+	a.mergeField("Sector", &a.Sector, b.Sector, b.Source)
+	a.mergeField("ShareClassFigi", &a.ShareClassFigi, b.ShareClassFigi, b.Source)
+	a.mergeField("SicCode", &a.SicCode, b.SicCode, b.Source)
+	a.mergeField("SicDescription", &a.SicDescription, b.SicDescription, b.Source)
+	a.mergeField("PhoneNumber", &a.PhoneNumber, b.PhoneNumber, b.Source)
+	a.mergeField("Address", &a.Address, b.Address, b.Source)
+
+	a.mergeFloat64Field("MarketCap", &a.MarketCap, b.MarketCap, b.Source)
+	a.mergeInt64Field("TotalEmployees", &a.TotalEmployees, b.TotalEmployees, b.Source)
+	a.mergeInt64Field("ShareClassSharesOutstanding", &a.ShareClassSharesOutstanding, b.ShareClassSharesOutstanding, b.Source)
+	a.mergeInt64Field("WeightedSharesOutstanding", &a.WeightedSharesOutstanding, b.WeightedSharesOutstanding, b.Source)
 
-	if b.DelistingDate != "" && a.DelistingDate != b.DelistingDate {
-		a.UpdateReason = fmt.Sprintf("DelistingDate changed '%s' to '%s'", a.DelistingDate, b.DelistingDate)
-		a.DelistingDate = b.DelistingDate
-		a.Updated = true
-		a.LastUpdated = time.Now().Unix()
-	}
+	return a
+}
 
-	if b.Description != "" && a.Description != b.Description {
-		a.UpdateReason = fmt.Sprintf("Description changed '%s' to '%s'", a.Description, b.Description)
-		a.Description = b.Description
-		a.Updated = true
-		a.LastUpdated = time.Now().Unix()
+// mergeField sets `dest` to `value` if value is not empty and differs from
+// the current value. The change is recorded on the asset.
+func (asset *Asset) mergeField(field string, dest *string, value string, source string) {
+	if value == "" || *dest == value {
+		return
 	}
 
-	if b.HeadquartersLocation != "" && a.HeadquartersLocation != b.HeadquartersLocation {
-		a.UpdateReason = fmt.Sprintf("HeadquartersLocation changed '%s' to '%s'", a.HeadquartersLocation, b.HeadquartersLocation)
-		a.HeadquartersLocation = b.HeadquartersLocation
-		a.Updated = true
-		a.LastUpdated = time.Now().Unix()
-	}
+	asset.RecordChange(field, *dest, value, source)
+	*dest = value
+	asset.Updated = true
+	asset.LastUpdated = time.Now().Unix()
+}
 
-	if b.ISIN != "" && a.ISIN != b.ISIN {
-		a.UpdateReason = fmt.Sprintf("ISIN changed '%s' to '%s'", a.ISIN, b.ISIN)
-		a.ISIN = b.ISIN
-		a.Updated = true
-		a.LastUpdated = time.Now().Unix()
+// mergeInt64Field is mergeField for integer fields; 0 is treated as empty
+func (asset *Asset) mergeInt64Field(field string, dest *int64, value int64, source string) {
+	if value == 0 || *dest == value {
+		return
 	}
 
-	if b.IconUrl != "" && a.IconUrl != b.IconUrl {
-		a.UpdateReason = fmt.Sprintf("IconUrl changed '%s' to '%s'", a.IconUrl, b.IconUrl)
-		a.IconUrl = b.IconUrl
-		a.Updated = true
-		a.LastUpdated = time.Now().Unix()
-	}
+	asset.RecordChange(field, FormatInt64(*dest), FormatInt64(value), source)
+	*dest = value
+	asset.Updated = true
+	asset.LastUpdated = time.Now().Unix()
+}
 
-	if b.Industry != "" && a.Industry != b.Industry {
-		a.UpdateReason = fmt.Sprintf("Industry changed '%s' to '%s'", a.Industry, b.Industry)
-		a.Industry = b.Industry
-		a.Updated = true
-		a.LastUpdated = time.Now().Unix()
+// mergeFloat64Field is mergeField for decimal fields; 0 is treated as empty
+func (asset *Asset) mergeFloat64Field(field string, dest *float64, value float64, source string) {
+	if value == 0 || *dest == value {
+		return
 	}
 
-	if b.ListingDate != "" && a.ListingDate != b.ListingDate {
-		a.UpdateReason = fmt.Sprintf("ListingDate changed '%s' to '%s'", a.ListingDate, b.ListingDate)
-		a.ListingDate = b.ListingDate
-		a.Updated = true
-		a.LastUpdated = time.Now().Unix()
-	}
+	asset.RecordChange(field, FormatFloat64(*dest), FormatFloat64(value), source)
+	*dest = value
+	asset.Updated = true
+	asset.LastUpdated = time.Now().Unix()
+}
 
-	if b.Name != "" && a.Name != b.Name {
-		a.UpdateReason = fmt.Sprintf("Name changed '%s' to '%s'", a.Name, b.Name)
-		a.Name = b.Name
-		a.Updated = true
-		a.LastUpdated = time.Now().Unix()
+// FormatInt64 formats an integer field for change records; 0 is formatted
+// as an empty string
+func FormatInt64(value int64) string {
+	if value == 0 {
+		return ""
 	}
+	return strconv.FormatInt(value, 10)
+}
 
-	if b.PrimaryExchange != "" && a.PrimaryExchange != b.PrimaryExchange {
-		a.UpdateReason = fmt.Sprintf("PrimaryExchange changed '%s' to '%s'", a.PrimaryExchange, b.PrimaryExchange)
-		a.PrimaryExchange = b.PrimaryExchange
-		a.Updated = true
-		a.LastUpdated = time.Now().Unix()
+// FormatFloat64 formats a decimal field for change records; 0 is formatted
+// as an empty string
+func FormatFloat64(value float64) string {
+	if value == 0 {
+		return ""
 	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
 
-	if b.Sector != "" && a.Sector != b.Sector {
-		a.UpdateReason = fmt.Sprintf("Sector changed '%s' to '%s'", a.Sector, b.Sector)
-		a.Sector = b.Sector
-		a.Updated = true
-		a.LastUpdated = time.Now().Unix()
-	}
+// RecordChange appends a record of `field` changing from `old` to `new` to
+// the list of changes made to the asset during this run
+func (asset *Asset) RecordChange(field, old, new, source string) {
+	asset.Changes = append(asset.Changes, &FieldChange{
+		Field:     field,
+		Old:       old,
+		New:       new,
+		Source:    source,
+		Timestamp: time.Now().Unix(),
+	})
+}
 
-	if b.ShareClassFigi != "" && a.ShareClassFigi != b.ShareClassFigi {
-		a.UpdateReason = fmt.Sprintf("ShareClassFigi changed '%s' to '%s'", a.ShareClassFigi, b.ShareClassFigi)
-		a.ShareClassFigi = b.ShareClassFigi
-		a.Updated = true
-		a.LastUpdated = time.Now().Unix()
+// LastChange returns the most recent change recorded for `field` or nil if
+// the field was not changed
+func (asset *Asset) LastChange(field string) *FieldChange {
+	for ii := len(asset.Changes) - 1; ii >= 0; ii-- {
+		if asset.Changes[ii].Field == field {
+			return asset.Changes[ii]
+		}
 	}
-
-	return a
+	return nil
 }
 
 func ReadAssetsFromParquet(fn string) []*Asset {
@@ -474,15 +556,17 @@ func ReadAssetsFromParquet(fn string) []*Asset {
 		return nil
 	}
 
-	pr, err := reader.NewParquetReader(fr, new(assetTmp), 4)
+	// read using the schema stored in the file so that files written before
+	// a column was added can still be loaded
+	pr, err := reader.NewParquetReader(fr, nil, 4)
 	if err != nil {
 		log.Error().Err(err).Msg("can't create parquet reader")
 		return nil
 	}
 
 	num := int(pr.GetNumRows())
-	rec := make([]*assetTmp, num)
-	if err = pr.Read(&rec); err != nil {
+	rows, err := pr.ReadByNumber(num)
+	if err != nil {
 		log.Error().Err(err).Msg("parquet read error")
 		return nil
 	}
@@ -490,30 +574,52 @@ func ReadAssetsFromParquet(fn string) []*Asset {
 	pr.ReadStop()
 	fr.Close()
 
-	assets := make([]*Asset, num)
+	rec := make([]*assetTmp, len(rows))
+	for ii, row := range rows {
+		rec[ii] = &assetTmp{}
+		copyParquetRow(row, rec[ii])
+	}
+
+	assets := make([]*Asset, len(rec))
 	for ii, asset := range rec {
 		assets[ii] = &Asset{
-			Ticker:               asset.Ticker,
-			Name:                 asset.Name,
-			Description:          asset.Description,
-			PrimaryExchange:      asset.PrimaryExchange,
-			AssetType:            AssetType(asset.AssetType),
-			CompositeFigi:        asset.CompositeFigi,
-			ShareClassFigi:       asset.ShareClassFigi,
-			CUSIP:                asset.CUSIP,
-			ISIN:                 asset.ISIN,
-			CIK:                  asset.CIK,
-			ListingDate:          asset.ListingDate,
-			DelistingDate:        asset.DelistingDate,
-			Industry:             asset.Industry,
-			Sector:               asset.Sector,
-			IconUrl:              asset.IconUrl,
-			CorporateUrl:         asset.CorporateUrl,
-			HeadquartersLocation: asset.HeadquartersLocation,
-			SimilarTickers:       asset.SimilarTickers,
-			PolygonDetailAge:     asset.PolygonDetailAge,
-			FidelityCusip:        asset.FidelityCusip,
-			LastUpdated:          asset.LastUpdated,
+			Ticker:                      asset.Ticker,
+			Name:                        asset.Name,
+			Description:                 asset.Description,
+			PrimaryExchange:             asset.PrimaryExchange,
+			AssetType:                   AssetType(asset.AssetType),
+			CompositeFigi:               asset.CompositeFigi,
+			ShareClassFigi:              asset.ShareClassFigi,
+			CUSIP:                       asset.CUSIP,
+			ISIN:                        asset.ISIN,
+			CIK:                         asset.CIK,
+			ListingDate:                 asset.ListingDate,
+			DelistingDate:               asset.DelistingDate,
+			Industry:                    asset.Industry,
+			Sector:                      asset.Sector,
+			IconUrl:                     asset.IconUrl,
+			LogoUrl:                     asset.LogoUrl,
+			IconHash:                    asset.IconHash,
+			LogoHash:                    asset.LogoHash,
+			CorporateUrl:                asset.CorporateUrl,
+			HeadquartersLocation:        asset.HeadquartersLocation,
+			HeadquartersCity:            asset.HeadquartersCity,
+			HeadquartersState:           asset.HeadquartersState,
+			HeadquartersPostalCode:      asset.HeadquartersPostalCode,
+			HeadquartersCountry:         asset.HeadquartersCountry,
+			SimilarTickers:              asset.SimilarTickers,
+			PolygonDetailAge:            asset.PolygonDetailAge,
+			FidelityCusip:               asset.FidelityCusip,
+			PreviousTicker:              asset.PreviousTicker,
+			MarketCap:                   asset.MarketCap,
+			SicCode:                     asset.SicCode,
+			SicDescription:              asset.SicDescription,
+			TotalEmployees:              asset.TotalEmployees,
+			PhoneNumber:                 asset.PhoneNumber,
+			Address:                     asset.Address,
+			ShareClassSharesOutstanding: asset.ShareClassSharesOutstanding,
+			WeightedSharesOutstanding:   asset.WeightedSharesOutstanding,
+			LastUpdated:                 asset.LastUpdated,
 		}
 	}
 
@@ -521,6 +627,41 @@ func ReadAssetsFromParquet(fn string) []*Asset {
 	return assets
 }
 
+// copyParquetRow copies the columns of `row`, as returned by
+// ParquetReader.ReadByNumber, to the fields of `dest` with the same parquet
+// name. Columns missing from the row leave the field at its zero value.
+func copyParquetRow(row interface{}, dest interface{}) {
+	src := reflect.ValueOf(row)
+	dst := reflect.ValueOf(dest).Elem()
+
+	for ii := 0; ii < dst.NumField(); ii++ {
+		name := parquetFieldName(dst.Type().Field(ii))
+		if name == "" {
+			continue
+		}
+		srcField := src.FieldByName(name)
+		if srcField.IsValid() && srcField.Type().AssignableTo(dst.Field(ii).Type()) {
+			dst.Field(ii).Set(srcField)
+		}
+	}
+}
+
+// parquetFieldName returns the name parquet-go uses for the field in dynamic
+// row types, i.e. the column name with its first letter upper-cased
+func parquetFieldName(field reflect.StructField) string {
+	for _, part := range strings.Split(field.Tag.Get("parquet"), ",") {
+		part = strings.TrimSpace(part)
+		if strings.HasPrefix(part, "name=") {
+			name := strings.TrimPrefix(part, "name=")
+			if name == "" {
+				return ""
+			}
+			return strings.ToUpper(name[:1]) + name[1:]
+		}
+	}
+	return ""
+}
+
 func SaveToParquet(records []*Asset, fn string) error {
 	var err error
 
@@ -564,22 +705,74 @@ func SaveToParquet(records []*Asset, fn string) error {
 	return nil
 }
 
-// SaveIcons writes icon images to disk. Each icon is name <dirpath>/ticker.png|jpeg
-func SaveIcons(assets []*Asset, dirpath string) {
+// ChangesParquetFileName returns the name of the per-run changes file stored
+// next to the assets parquet file `fn`
+func ChangesParquetFileName(fn string, runTime time.Time) string {
+	ext := filepath.Ext(fn)
+	base := strings.TrimSuffix(fn, ext)
+	return fmt.Sprintf("%s-changes-%s%s", base, runTime.UTC().Format("20060102T150405"), ext)
+}
+
+// SaveChangesToParquet writes each change recorded on `assets` as a row in
+// the parquet file `fn`. The first row that could not be written is returned
+// as an error after the rest of the file is written.
+func SaveChangesToParquet(assets []*Asset, fn string) error {
+	var err error
+
+	fh, err := local.NewLocalFileWriter(fn)
+	if err != nil {
+		log.Error().Err(err).Str("FileName", fn).Msg("cannot create local file")
+		return err
+	}
+	defer fh.Close()
+
+	pw, err := writer.NewParquetWriter(fh, new(fieldChangeRecord), 4)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("Parquet write failed")
+		return err
+	}
+
+	pw.RowGroupSize = 128 * 1024 * 1024 // 128M
+	pw.PageSize = 8 * 1024              // 8k
+	pw.CompressionType = parquet.CompressionCodec_GZIP
+
+	numChanges := 0
+	var writeErr error
 	for _, asset := range assets {
-		subLog := log.With().Str("Ticker", asset.Ticker).Logger()
-		if len(asset.Icon) == 0 {
-			continue
-		}
-		data := bytes.NewReader(asset.Icon)
-		_, imType, err := image.Decode(data)
-		if err != nil {
-			subLog.Error().Err(err).Msg("failed to read image data")
-			continue
+		for _, change := range asset.Changes {
+			record := &fieldChangeRecord{
+				Ticker:        asset.Ticker,
+				CompositeFigi: asset.CompositeFigi,
+				Field:         change.Field,
+				Old:           change.Old,
+				New:           change.New,
+				Source:        change.Source,
+				Timestamp:     change.Timestamp,
+			}
+			if err = pw.Write(record); err != nil {
+				log.Error().
+					Err(err).
+					Str("Ticker", asset.Ticker).
+					Str("Field", change.Field).
+					Msg("Parquet write failed for change record")
+				if writeErr == nil {
+					writeErr = err
+				}
+				continue
+			}
+			numChanges++
 		}
+	}
 
-		os.WriteFile(fmt.Sprintf("%s/%s.%s", dirpath, asset.Ticker, imType), asset.Icon, 0666)
+	if err = pw.WriteStop(); err != nil {
+		log.Error().Err(err).Msg("Parquet write failed")
+		return err
 	}
+
+	log.Info().Int("NumChanges", numChanges).Str("FileName", fn).Msg("changes parquet write finished")
+	return writeErr
 }
 
 // ActiveAssetsFromDatabase loads all active assets from the database
@@ -641,19 +834,49 @@ func (asset *Asset) MarshalZerologObject(e *zerolog.Event) {
 	e.Str("Industry", asset.Industry)
 	e.Str("Sector", asset.Sector)
 	e.Str("IconUrl", asset.IconUrl)
+	e.Str("LogoUrl", asset.LogoUrl)
+	e.Str("IconHash", asset.IconHash)
+	e.Str("LogoHash", asset.LogoHash)
 	e.Str("CorporateUrl", asset.CorporateUrl)
 	e.Str("HeadquartersLocation", asset.HeadquartersLocation)
+	e.Str("HeadquartersCity", asset.HeadquartersCity)
+	e.Str("HeadquartersState", asset.HeadquartersState)
+	e.Str("HeadquartersPostalCode", asset.HeadquartersPostalCode)
+	e.Str("HeadquartersCountry", asset.HeadquartersCountry)
+	e.Str("PreviousTicker", asset.PreviousTicker)
+	e.Float64("MarketCap", asset.MarketCap)
+	e.Str("SicCode", asset.SicCode)
+	e.Str("SicDescription", asset.SicDescription)
+	e.Int64("TotalEmployees", asset.TotalEmployees)
+	e.Str("PhoneNumber", asset.PhoneNumber)
+	e.Str("Address", asset.Address)
+	e.Int64("ShareClassSharesOutstanding", asset.ShareClassSharesOutstanding)
+	e.Int64("WeightedSharesOutstanding", asset.WeightedSharesOutstanding)
 	e.Str("Source", asset.Source)
 	e.Int64("PolygonDetailAge", asset.PolygonDetailAge)
 	e.Int64("LastUpdate", asset.LastUpdated)
 }
 
+func (change *FieldChange) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("Field", change.Field)
+	e.Str("Old", truncate(change.Old, 80))
+	e.Str("New", truncate(change.New, 80))
+	e.Str("Source", change.Source)
+	e.Int64("Timestamp", change.Timestamp)
+}
+
+func (changes FieldChanges) MarshalZerologArray(a *zerolog.Array) {
+	for _, change := range changes {
+		a.Object(change)
+	}
+}
+
 // LogSummary logs statistics about each signficant asset change
 func LogSummary(assets []*Asset) {
 	// Changed Assets
 	for _, asset := range assets {
 		if asset.Updated {
-			log.Info().Object("Asset", asset).Msg("changed")
+			log.Info().Object("Asset", asset).Array("Changes", asset.Changes).Msg("changed")
 		}
 	}
 }
@@ -0,0 +1,73 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"errors"
+	"os"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SaveCheckpoint writes the assets of an interrupted run, including the
+// enrichment completed so far, to the parquet file `fn`
+func SaveCheckpoint(assets []*Asset, fn string) error {
+	log.Info().Str("FileName", fn).Int("NumAssets", len(assets)).Msg("saving checkpoint")
+	return SaveToParquet(assets, fn)
+}
+
+// ApplyCheckpoint merges the enrichment saved by an interrupted run in the
+// checkpoint file `fn` into `assets` so it isn't looked up again. Assets are
+// matched by ticker; checkpointed assets with a different composite figi or
+// that were delisted are ignored. A missing checkpoint is not an error.
+func ApplyCheckpoint(assets []*Asset, fn string) int {
+	if _, err := os.Stat(fn); errors.Is(err, os.ErrNotExist) {
+		return 0
+	}
+
+	checkpoint := ReadAssetsFromParquet(fn)
+	assetMap := BuildAssetMap(assets)
+
+	applied := 0
+	for _, saved := range checkpoint {
+		asset, ok := assetMap[saved.Ticker]
+		if !ok || saved.DelistingDate != "" {
+			continue
+		}
+
+		if asset.CompositeFigi != "" && saved.CompositeFigi != "" && asset.CompositeFigi != saved.CompositeFigi {
+			log.Warn().Str("Ticker", asset.Ticker).Str("CompositeFigi", asset.CompositeFigi).Str("CheckpointCompositeFigi", saved.CompositeFigi).Msg("ignoring checkpoint for asset with different composite figi")
+			continue
+		}
+
+		saved.Source = "checkpoint"
+		MergeAsset(asset, saved)
+		if saved.PolygonDetailAge > asset.PolygonDetailAge {
+			asset.PolygonDetailAge = saved.PolygonDetailAge
+		}
+		applied++
+	}
+
+	log.Info().Str("FileName", fn).Int("NumAssets", applied).Msg("applied checkpoint from interrupted run")
+	return applied
+}
+
+// RemoveCheckpoint deletes the checkpoint file `fn` once its enrichment has
+// been saved
+func RemoveCheckpoint(fn string) {
+	if err := os.Remove(fn); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warn().Err(err).Str("FileName", fn).Msg("could not remove checkpoint")
+	}
+}
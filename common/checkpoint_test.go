@@ -0,0 +1,67 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyCheckpoint(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "checkpoint.parquet")
+	saved := []*Asset{
+		{Ticker: "AAPL", AssetType: CommonStock, CompositeFigi: "BBG000B9XRY4", Name: "Apple Inc.", Industry: "Technology", PolygonDetailAge: 1000},
+		{Ticker: "MSFT", AssetType: CommonStock, CompositeFigi: "BBG000BPH459", Name: "Microsoft"},
+		{Ticker: "GONE", AssetType: CommonStock, CompositeFigi: "BBG000BLNNH6", Name: "Delisted", DelistingDate: "2022-01-03"},
+	}
+	if err := SaveCheckpoint(saved, fn); err != nil {
+		t.Fatalf("SaveCheckpoint failed: %v", err)
+	}
+
+	assets := []*Asset{
+		{Ticker: "AAPL", AssetType: CommonStock, CompositeFigi: "BBG000B9XRY4"},
+		// the ticker was reused by a different security since the checkpoint
+		{Ticker: "MSFT", AssetType: CommonStock, CompositeFigi: "BBG000B9Y5X2"},
+		{Ticker: "GONE", AssetType: CommonStock, CompositeFigi: "BBG000BLNNH6"},
+	}
+
+	if applied := ApplyCheckpoint(assets, fn); applied != 1 {
+		t.Errorf("applied %d assets, want 1", applied)
+	}
+
+	aapl := assets[0]
+	if aapl.Name != "Apple Inc." || aapl.Industry != "Technology" || aapl.PolygonDetailAge != 1000 {
+		t.Errorf("AAPL = %+v, want checkpointed enrichment", aapl)
+	}
+	if assets[1].Name != "" {
+		t.Errorf("MSFT name = %q, want checkpoint ignored for a different composite figi", assets[1].Name)
+	}
+	if assets[2].Name != "" {
+		t.Errorf("GONE name = %q, want delisted checkpoint ignored", assets[2].Name)
+	}
+
+	RemoveCheckpoint(fn)
+	if _, err := os.Stat(fn); !os.IsNotExist(err) {
+		t.Errorf("checkpoint was not removed: %v", err)
+	}
+}
+
+func TestApplyCheckpointMissingFile(t *testing.T) {
+	assets := []*Asset{{Ticker: "AAPL"}}
+	if applied := ApplyCheckpoint(assets, filepath.Join(t.TempDir(), "missing.parquet")); applied != 0 {
+		t.Errorf("applied %d assets from a missing checkpoint, want 0", applied)
+	}
+}
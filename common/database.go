@@ -16,6 +16,8 @@ package common
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v4"
@@ -23,6 +25,8 @@ import (
 	"github.com/spf13/viper"
 )
 
+// SaveToDatabase upserts assets into the assets table. When database.history
+// is set each version of an asset is also recorded in assets_history.
 func SaveToDatabase(assets []*Asset) error {
 	log.Info().Msg("saving to database")
 	ctx := context.Background()
@@ -38,6 +42,29 @@ func SaveToDatabase(assets []*Asset) error {
 		return err
 	}
 
+	now := time.Now()
+	saveHistory := viper.GetBool("database.history")
+	if saveHistory {
+		var exists bool
+		if err := tx.QueryRow(ctx, `SELECT to_regclass('assets_history') IS NOT NULL`).Scan(&exists); err != nil {
+			log.Error().Err(err).Msg("could not check for the assets_history table")
+			tx.Rollback(ctx)
+			return err
+		}
+		if !exists {
+			log.Warn().Msg("assets_history table does not exist - skipping asset history; create it with sql/assets_history.sql or pass --database-history=false")
+			saveHistory = false
+		}
+	}
+
+	columns, err := assetColumnsInDatabase(ctx, tx)
+	if err != nil {
+		log.Error().Err(err).Msg("could not check the columns of the assets table")
+		tx.Rollback(ctx)
+		return err
+	}
+	upsert := assetUpsert(columns)
+
 	// reset active, new, and updated flags
 	_, err = tx.Exec(ctx,
 		`UPDATE assets SET active=False, updated=False, new=False`)
@@ -65,99 +92,369 @@ func SaveToDatabase(assets []*Asset) error {
 			}
 		}
 
+		_, err := tx.Exec(ctx, upsert, assetValues(asset, columns)...)
+		if err != nil {
+			log.Error().Err(err).Object("Asset", asset).Msg("error saving asset to database")
+			tx.Rollback(ctx)
+			return err
+		}
+
+		if saveHistory {
+			if err := saveAssetHistory(ctx, tx, asset, listingDate, delistingDate, now); err != nil {
+				log.Error().Err(err).Object("Asset", asset).Msg("error saving asset history to database")
+				tx.Rollback(ctx)
+				return err
+			}
+		}
+	}
+
+	// assets that weren't saved were deactivated by the reset above
+	if saveHistory {
+		if err := closeInactiveHistory(ctx, tx, now); err != nil {
+			log.Error().Err(err).Msg("error closing history of inactive assets")
+			tx.Rollback(ctx)
+			return err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msg("error commiting tx to database")
+		return err
+	}
+
+	return nil
+}
+
+// assetColumn is a column of the assets table and the asset field saved to it
+type assetColumn struct {
+	name string
+	// insertOnly columns aren't changed when the asset already exists
+	insertOnly bool
+	value      func(asset *Asset) interface{}
+}
+
+// assetMigration is a group of columns added to the assets table by a script
+// in sql/ that has to be run by hand
+type assetMigration struct {
+	script  string
+	columns []*assetColumn
+}
+
+// assetColumns are the columns of the assets table that always exist
+var assetColumns = []*assetColumn{
+	{name: "ticker", insertOnly: true, value: func(asset *Asset) interface{} { return asset.Ticker }},
+	{name: "asset_type", insertOnly: true, value: func(asset *Asset) interface{} { return asset.AssetType }},
+	{name: "cik", value: func(asset *Asset) interface{} { return asset.CIK }},
+	{name: "composite_figi", value: func(asset *Asset) interface{} { return asset.CompositeFigi }},
+	{name: "share_class_figi", value: func(asset *Asset) interface{} { return asset.ShareClassFigi }},
+	{name: "primary_exchange", value: func(asset *Asset) interface{} { return asset.PrimaryExchange }},
+	{name: "cusip", value: func(asset *Asset) interface{} { return asset.CUSIP }},
+	{name: "isin", value: func(asset *Asset) interface{} { return asset.ISIN }},
+	{name: "active", value: func(asset *Asset) interface{} { return asset.DelistingDate == "" }},
+	{name: "name", value: func(asset *Asset) interface{} { return asset.Name }},
+	{name: "description", value: func(asset *Asset) interface{} { return asset.Description }},
+	{name: "corporate_url", value: func(asset *Asset) interface{} { return asset.CorporateUrl }},
+	{name: "sector", value: func(asset *Asset) interface{} { return asset.Sector }},
+	{name: "industry", value: func(asset *Asset) interface{} { return asset.Industry }},
+	{name: "logo_url", value: func(asset *Asset) interface{} { return asset.IconUrl }},
+	{name: "similar_tickers", value: func(asset *Asset) interface{} { return asset.SimilarTickers }},
+	{name: "new", insertOnly: true, value: func(asset *Asset) interface{} { return true }},
+	{name: "updated", value: func(asset *Asset) interface{} { return asset.Updated }},
+	{name: "listed_utc", value: func(asset *Asset) interface{} { return nullString(asset.ListingDate) }},
+	{name: "delisted_utc", value: func(asset *Asset) interface{} { return nullString(asset.DelistingDate) }},
+	{name: "last_updated_utc", value: func(asset *Asset) interface{} { return time.Unix(asset.LastUpdated, 0) }},
+	{name: "source", value: func(asset *Asset) interface{} { return asset.Source }},
+}
+
+// assetMigrations are the optional columns of the assets table. Columns of a
+// migration that hasn't been run are not saved.
+var assetMigrations = []*assetMigration{
+	{
+		script: "sql/assets_polygon_detail.sql",
+		columns: []*assetColumn{
+			{name: "market_cap", value: func(asset *Asset) interface{} { return asset.MarketCap }},
+			{name: "sic_code", value: func(asset *Asset) interface{} { return asset.SicCode }},
+			{name: "sic_description", value: func(asset *Asset) interface{} { return asset.SicDescription }},
+			{name: "total_employees", value: func(asset *Asset) interface{} { return asset.TotalEmployees }},
+			{name: "phone_number", value: func(asset *Asset) interface{} { return asset.PhoneNumber }},
+			{name: "address", value: func(asset *Asset) interface{} { return asset.Address }},
+			{name: "share_class_shares_outstanding", value: func(asset *Asset) interface{} { return asset.ShareClassSharesOutstanding }},
+			{name: "weighted_shares_outstanding", value: func(asset *Asset) interface{} { return asset.WeightedSharesOutstanding }},
+		},
+	},
+	{
+		script: "sql/assets_headquarters.sql",
+		columns: []*assetColumn{
+			{name: "headquarters_location", value: func(asset *Asset) interface{} { return asset.HeadquartersLocation }},
+			{name: "headquarters_city", value: func(asset *Asset) interface{} { return asset.HeadquartersCity }},
+			{name: "headquarters_state", value: func(asset *Asset) interface{} { return asset.HeadquartersState }},
+			{name: "headquarters_postal_code", value: func(asset *Asset) interface{} { return asset.HeadquartersPostalCode }},
+			{name: "headquarters_country", value: func(asset *Asset) interface{} { return asset.HeadquartersCountry }},
+		},
+	},
+	{
+		script: "sql/assets_icons.sql",
+		columns: []*assetColumn{
+			{name: "icon_hash", value: func(asset *Asset) interface{} { return asset.IconHash }},
+			{name: "logo_hash", value: func(asset *Asset) interface{} { return asset.LogoHash }},
+		},
+	},
+}
+
+// nullString returns nil for an empty string so it is saved as NULL
+func nullString(val string) *string {
+	if val == "" {
+		return nil
+	}
+	return &val
+}
+
+// assetColumnsInDatabase returns the columns to save assets to. Migrations
+// whose columns are missing from the assets table are skipped with a warning.
+func assetColumnsInDatabase(ctx context.Context, tx pgx.Tx) ([]*assetColumn, error) {
+	rows, err := tx.Query(ctx,
+		`SELECT attname FROM pg_attribute
+		WHERE attrelid = 'assets'::regclass AND attnum > 0 AND NOT attisdropped`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		existing[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	columns := make([]*assetColumn, 0, len(assetColumns))
+	columns = append(columns, assetColumns...)
+	for _, migration := range assetMigrations {
+		missing := make([]string, 0)
+		for _, column := range migration.columns {
+			if !existing[column.name] {
+				missing = append(missing, column.name)
+			}
+		}
+		if len(missing) > 0 {
+			log.Warn().Strs("Columns", missing).Str("Script", migration.script).Msg("assets table is missing columns - skipping them; add them by running the script")
+			continue
+		}
+		columns = append(columns, migration.columns...)
+	}
+	return columns, nil
+}
+
+// assetUpsert returns the statement that inserts an asset into `columns` of
+// the assets table or updates the existing row
+func assetUpsert(columns []*assetColumn) string {
+	names := make([]string, len(columns))
+	params := make([]string, len(columns))
+	updates := make([]string, 0, len(columns))
+	for ii, column := range columns {
+		names[ii] = fmt.Sprintf("%q", column.name)
+		params[ii] = fmt.Sprintf("$%d", ii+1)
+		if !column.insertOnly {
+			updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", column.name, column.name))
+		}
+	}
+	return fmt.Sprintf(`INSERT INTO assets (%s) VALUES (%s)
+		ON CONFLICT ON CONSTRAINT assets_pkey
+		DO UPDATE SET %s;`,
+		strings.Join(names, ", "), strings.Join(params, ", "), strings.Join(updates, ", "))
+}
+
+// assetValues returns the values of `asset` saved to `columns`
+func assetValues(asset *Asset, columns []*assetColumn) []interface{} {
+	values := make([]interface{}, len(columns))
+	for ii, column := range columns {
+		values[ii] = column.value(asset)
+	}
+	return values
+}
+
+// closeInactiveHistory closes the current history row of assets that are no
+// longer active but whose history still records them as active
+func closeInactiveHistory(ctx context.Context, tx pgx.Tx, now time.Time) error {
+	_, err := tx.Exec(ctx,
+		`UPDATE assets_history h SET valid_to = $1
+		FROM assets a
+		WHERE h.ticker = a.ticker AND h.asset_type = a.asset_type::text
+			AND h.valid_to IS NULL AND h.active AND NOT a.active`,
+		now,
+	)
+	return err
+}
+
+// saveAssetHistory records the current version of `asset` in the
+// assets_history table. If the open history row for the asset differs from
+// the asset it is closed as of `now` and a new row is opened; unchanged
+// assets are left alone.
+func saveAssetHistory(ctx context.Context, tx pgx.Tx, asset *Asset, listingDate, delistingDate *string, now time.Time) error {
+	values := []interface{}{
+		asset.Ticker,
+		string(asset.AssetType),
+		asset.Name,
+		asset.Description,
+		asset.PrimaryExchange,
+		asset.CompositeFigi,
+		asset.ShareClassFigi,
+		asset.CUSIP,
+		asset.ISIN,
+		asset.CIK,
+		asset.Sector,
+		asset.Industry,
+		asset.CorporateUrl,
+		asset.IconUrl,
+		listingDate,
+		delistingDate,
+		asset.DelistingDate == "",
+	}
+
+	// close the current version if anything changed
+	_, err := tx.Exec(ctx,
+		`UPDATE assets_history SET valid_to = $18
+		WHERE ticker = $1 AND asset_type = $2 AND valid_to IS NULL AND (
+			name,
+			description,
+			primary_exchange,
+			composite_figi,
+			share_class_figi,
+			cusip,
+			isin,
+			cik,
+			sector,
+			industry,
+			corporate_url,
+			logo_url,
+			listed_utc,
+			delisted_utc,
+			active
+		) IS DISTINCT FROM (
+			$3::text,
+			$4::text,
+			$5::text,
+			$6::text,
+			$7::text,
+			$8::text,
+			$9::text,
+			$10::text,
+			$11::text,
+			$12::text,
+			$13::text,
+			$14::text,
+			$15::timestamp,
+			$16::timestamp,
+			$17::boolean
+		)`,
+		append(values[:len(values):len(values)], now)...,
+	)
+	if err != nil {
+		return err
+	}
+
+	// open a new version if there isn't a current one
+	_, err = tx.Exec(ctx,
+		`INSERT INTO assets_history (
+			"ticker",
+			"asset_type",
+			"name",
+			"description",
+			"primary_exchange",
+			"composite_figi",
+			"share_class_figi",
+			"cusip",
+			"isin",
+			"cik",
+			"sector",
+			"industry",
+			"corporate_url",
+			"logo_url",
+			"listed_utc",
+			"delisted_utc",
+			"active",
+			"source",
+			"valid_from"
+		) SELECT
+			$1::text,
+			$2::text,
+			$3::text,
+			$4::text,
+			$5::text,
+			$6::text,
+			$7::text,
+			$8::text,
+			$9::text,
+			$10::text,
+			$11::text,
+			$12::text,
+			$13::text,
+			$14::text,
+			$15::timestamp,
+			$16::timestamp,
+			$17::boolean,
+			$18::text,
+			$19::timestamptz
+		WHERE NOT EXISTS (
+			SELECT 1 FROM assets_history
+			WHERE ticker = $1 AND asset_type = $2 AND valid_to IS NULL
+		);`,
+		append(values[:len(values):len(values)], asset.Source, now)...,
+	)
+	return err
+}
+
+// SaveTickerChangesToDatabase inserts ticker changes into the ticker_changes
+// table. Changes that are already in the table are ignored.
+func SaveTickerChangesToDatabase(changes []*TickerChange) error {
+	log.Info().Int("NumChanges", len(changes)).Msg("saving ticker changes to database")
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, viper.GetString("database.url"))
+	if err != nil {
+		log.Error().Err(err).Msg("could not connect to database")
+		return err
+	}
+	defer conn.Close(ctx)
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("could not begin transaction")
+		return err
+	}
+
+	for _, change := range changes {
+		// event_date is required
+		if change.Date == "" {
+			log.Warn().Str("CompositeFigi", change.CompositeFigi).Str("OldTicker", change.OldTicker).Str("NewTicker", change.NewTicker).Msg("skipping ticker change without a date")
+			continue
+		}
+
 		_, err := tx.Exec(ctx,
-			`INSERT INTO assets (
-				"ticker",
-				"asset_type",
-				"cik",
+			`INSERT INTO ticker_changes (
 				"composite_figi",
-				"share_class_figi",
-				"primary_exchange",
-				"cusip",
-				"isin",
-				"active",
-				"name",
-				"description",
-				"corporate_url",
-				"sector",
-				"industry",
-				"logo_url",
-				"similar_tickers",
-				"new",
-				"updated",
-				"listed_utc",
-				"delisted_utc",
-				"last_updated_utc",
+				"old_ticker",
+				"new_ticker",
+				"event_date",
 				"source"
 			) VALUES (
 				$1,
 				$2,
 				$3,
 				$4,
-				$5,
-				$6,
-				$7,
-				$8,
-				$9,
-				$10,
-				$11,
-				$12,
-				$13,
-				$14,
-				$15,
-				$16,
-				't',
-				$17,
-				$18,
-				$19,
-				$20,
-				$21
-			) ON CONFLICT ON CONSTRAINT assets_pkey
-			DO UPDATE SET
-				cik = EXCLUDED.cik,
-				composite_figi = EXCLUDED.composite_figi,
-				share_class_figi = EXCLUDED.share_class_figi,
-				primary_exchange = EXCLUDED.primary_exchange,
-				cusip = EXCLUDED.cusip,
-				isin = EXCLUDED.isin,
-				active = EXCLUDED.active,
-				name = EXCLUDED.name,
-				description = EXCLUDED.description,
-				corporate_url = EXCLUDED.corporate_url,
-				sector = EXCLUDED.sector,
-				industry = EXCLUDED.industry,
-				logo_url = EXCLUDED.logo_url,
-				similar_tickers = EXCLUDED.similar_tickers,
-				updated = EXCLUDED.updated,
-				listed_utc = EXCLUDED.listed_utc,
-				delisted_utc = EXCLUDED.delisted_utc,
-				last_updated_utc = EXCLUDED.last_updated_utc,
-				source = EXCLUDED.source
-			;`,
-			asset.Ticker,
-			asset.AssetType,
-			asset.CIK,
-			asset.CompositeFigi,
-			asset.ShareClassFigi,
-			asset.PrimaryExchange,
-			asset.CUSIP,
-			asset.ISIN,
-			asset.DelistingDate == "",
-			asset.Name,
-			asset.Description,
-			asset.CorporateUrl,
-			asset.Sector,
-			asset.Industry,
-			asset.IconUrl,
-			asset.SimilarTickers,
-			asset.Updated,
-			listingDate,
-			delistingDate,
-			time.Unix(asset.LastUpdated, 0),
-			asset.Source,
+				$5
+			) ON CONFLICT DO NOTHING;`,
+			change.CompositeFigi,
+			change.OldTicker,
+			change.NewTicker,
+			change.Date,
+			change.Source,
 		)
 		if err != nil {
-			log.Error().Err(err).Object("Asset", asset).Msg("error saving asset to database")
+			log.Error().Err(err).Str("CompositeFigi", change.CompositeFigi).Str("OldTicker", change.OldTicker).Str("NewTicker", change.NewTicker).Msg("error saving ticker change to database")
 			tx.Rollback(ctx)
 			return err
 		}
@@ -0,0 +1,59 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAssetUpsert(t *testing.T) {
+	columns := []*assetColumn{
+		{name: "ticker", insertOnly: true, value: func(asset *Asset) interface{} { return asset.Ticker }},
+		{name: "name", value: func(asset *Asset) interface{} { return asset.Name }},
+		{name: "listed_utc", value: func(asset *Asset) interface{} { return nullString(asset.ListingDate) }},
+	}
+
+	upsert := assetUpsert(columns)
+	if !strings.Contains(upsert, `INSERT INTO assets ("ticker", "name", "listed_utc") VALUES ($1, $2, $3)`) {
+		t.Errorf("insert = %s", upsert)
+	}
+	if !strings.Contains(upsert, "DO UPDATE SET name = EXCLUDED.name, listed_utc = EXCLUDED.listed_utc;") {
+		t.Errorf("update = %s", upsert)
+	}
+
+	values := assetValues(&Asset{Ticker: "AAPL", Name: "Apple Inc."}, columns)
+	if len(values) != 3 || values[0] != "AAPL" || values[1] != "Apple Inc." {
+		t.Errorf("values = %v", values)
+	}
+	if listed, ok := values[2].(*string); !ok || listed != nil {
+		t.Errorf("listed_utc = %v, want NULL", values[2])
+	}
+}
+
+func TestAssetMigrations(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, column := range assetColumns {
+		seen[column.name] = true
+	}
+	for _, migration := range assetMigrations {
+		for _, column := range migration.columns {
+			if seen[column.name] {
+				t.Errorf("%s from %s is saved more than once", column.name, migration.script)
+			}
+			seen[column.name] = true
+		}
+	}
+}
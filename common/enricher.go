@@ -0,0 +1,193 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// Enricher is a stage of the import pipeline that adds information to assets
+type Enricher interface {
+	// Name is the key used to reference the stage in the config file
+	Name() string
+
+	// Enrich updates assets with data from the provider. At most `max` assets
+	// are looked up; 0 means no limit. Lookups stop when `ctx` is cancelled.
+	// The returned list replaces `assets` for subsequent stages.
+	Enrich(ctx context.Context, assets []*Asset, max int) []*Asset
+}
+
+// HeldAsset is an asset an enrichment stage took out of the run without it
+// being delisted. When Keep is true the version saved by the previous run is
+// saved again unchanged; otherwise the asset is left out of the save.
+type HeldAsset struct {
+	Asset  *Asset
+	Reason string
+	Keep   bool
+}
+
+// AssetHolder is implemented by enrichment stages that hold assets back
+// from the save. Held returns the assets held by the last call to Enrich.
+type AssetHolder interface {
+	Held() []*HeldAsset
+}
+
+// EnricherError is implemented by enrichment stages whose failure must stop
+// the import. Err returns the error of the last call to Enrich.
+type EnricherError interface {
+	Err() error
+}
+
+// EnricherConfig describes a single entry in an `enrichers` list of the config file
+type EnricherConfig struct {
+	Name     string `mapstructure:"name"`
+	Max      int    `mapstructure:"max"`
+	Disabled bool   `mapstructure:"disabled"`
+}
+
+// RecommendedEnrichers are the stages that keep invalid assets out of the
+// saved universe; a warning is logged when a configured list omits them
+var RecommendedEnrichers = []string{"clean", "validate"}
+
+// DefaultEnrichers is the list of stages used when none are configured
+var DefaultEnrichers = []*EnricherConfig{
+	{Name: "polygon", Max: 5},
+	{Name: "figi"},
+	{Name: "clean"},
+	{Name: "tiingo", Max: 5},
+	{Name: "yfinance", Max: 5},
+	{Name: "validate"},
+}
+
+var (
+	enricherMu       sync.RWMutex
+	enricherRegistry = make(map[string]Enricher)
+)
+
+func init() {
+	RegisterEnricher(&cleanEnricher{})
+}
+
+// RegisterEnricher makes an enrichment stage available by name to the import
+// pipeline. It is typically called from the init function of the provider
+// package.
+func RegisterEnricher(enricher Enricher) {
+	enricherMu.Lock()
+	defer enricherMu.Unlock()
+
+	name := enricher.Name()
+	if _, ok := enricherRegistry[name]; ok {
+		panic(fmt.Sprintf("enricher %s registered twice", name))
+	}
+	enricherRegistry[name] = enricher
+}
+
+// LookupEnricher returns the registered enricher with the given name
+func LookupEnricher(name string) (Enricher, error) {
+	enricherMu.RLock()
+	defer enricherMu.RUnlock()
+
+	enricher, ok := enricherRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown enricher '%s'", name)
+	}
+	return enricher, nil
+}
+
+// RegisteredEnrichers returns the names of all registered enrichers in sorted order
+func RegisteredEnrichers() []string {
+	enricherMu.RLock()
+	defer enricherMu.RUnlock()
+
+	names := make([]string, 0, len(enricherRegistry))
+	for name := range enricherRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ConfiguredEnrichers reads the ordered list of enrichment stages for the
+// given run profile. Stages are read from `profiles.<profile>.enrichers` when
+// a profile is set and from `enrichers` otherwise. Disabled stages are
+// omitted. If no stages are configured DefaultEnrichers is used. A warning
+// is logged for each of RecommendedEnrichers missing from the list.
+func ConfiguredEnrichers(profile string) ([]*EnricherConfig, error) {
+	key := "enrichers"
+	if profile != "" {
+		key = fmt.Sprintf("profiles.%s.enrichers", profile)
+		if !viper.IsSet(key) {
+			return nil, fmt.Errorf("profile '%s' has no enrichers configured", profile)
+		}
+	}
+
+	var enricherConfigs []*EnricherConfig
+	if err := viper.UnmarshalKey(key, &enricherConfigs); err != nil {
+		return nil, err
+	}
+
+	if len(enricherConfigs) == 0 {
+		enricherConfigs = DefaultEnrichers
+	}
+
+	enabled := make([]*EnricherConfig, 0, len(enricherConfigs))
+	for _, enricherConfig := range enricherConfigs {
+		if enricherConfig.Disabled {
+			continue
+		}
+		if _, err := LookupEnricher(enricherConfig.Name); err != nil {
+			return nil, err
+		}
+		enabled = append(enabled, enricherConfig)
+	}
+
+	for _, name := range RecommendedEnrichers {
+		found := false
+		for _, enricherConfig := range enabled {
+			if enricherConfig.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.Warn().Str("Enricher", name).Str("Profile", profile).Msg("enricher is not configured; assets it would remove or hold will be saved")
+		}
+	}
+
+	return enabled, nil
+}
+
+// cleanEnricher removes assets without a composite figi or asset type and
+// trims whitespace from text fields
+type cleanEnricher struct{}
+
+func (e *cleanEnricher) Name() string {
+	return "clean"
+}
+
+func (e *cleanEnricher) Enrich(ctx context.Context, assets []*Asset, max int) []*Asset {
+	beforeCleanCnt := len(assets)
+	assets = CleanAssets(assets)
+	afterCleanCnt := len(assets)
+	log.Debug().Int("RemovedAssetCount", beforeCleanCnt-afterCleanCnt).Msg("Removed assets with no FIGI or Asset Type")
+	TrimWhiteSpace(assets)
+	return assets
+}
@@ -18,4 +18,6 @@ const (
 	EXIT_CODE_POLYGON                  = 64
 	EXIT_CODE_ASSET_COUNT_OUT_OF_RANGE = 65
 	EXIT_CODE_DATABASE_ERROR           = 66
+	EXIT_CODE_SOURCE                   = 67
+	EXIT_CODE_INTERRUPTED              = 68
 )
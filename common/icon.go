@@ -0,0 +1,178 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var ErrNotAnImage = errors.New("data is not a supported image type")
+
+// ImageExt validates that `data` is a png, jpeg, gif, or svg image and
+// returns the file extension for the image type
+func ImageExt(data []byte) (string, error) {
+	if len(data) == 0 {
+		return "", ErrNotAnImage
+	}
+
+	if _, imType, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		if imType == "jpeg" {
+			return "jpg", nil
+		}
+		return imType, nil
+	}
+
+	// svg images are xml documents with an <svg> root element
+	contentType := http.DetectContentType(data)
+	if strings.HasPrefix(contentType, "text/xml") || strings.HasPrefix(contentType, "text/plain") {
+		head := data
+		if len(head) > 1024 {
+			head = head[:1024]
+		}
+		if bytes.Contains(bytes.ToLower(head), []byte("<svg")) {
+			return "svg", nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrNotAnImage, contentType)
+}
+
+// SaveImage validates `data` and writes it to `dir` under its content
+// address: the sha256 hash of the image followed by the file extension of
+// the image type. The content address is returned. Images already in `dir`
+// are not re-written.
+func SaveImage(data []byte, dir string) (string, error) {
+	ext, err := ImageExt(data)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	name := fmt.Sprintf("%s.%s", hex.EncodeToString(sum[:]), ext)
+	fn := filepath.Join(dir, name)
+
+	if _, err := os.Stat(fn); err == nil {
+		return name, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(fn, data, 0644); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// SaveIcons writes the icon and logo images downloaded for each asset to
+// `dirpath` by content address (see SaveImage) and records the address in
+// IconHash and LogoHash. Image data is released once saved.
+func SaveIcons(assets []*Asset, dirpath string) {
+	for _, asset := range assets {
+		subLog := log.With().Str("Ticker", asset.Ticker).Logger()
+
+		images := []struct {
+			field string
+			data  *[]byte
+			hash  *string
+		}{
+			{"IconHash", &asset.Icon, &asset.IconHash},
+			{"LogoHash", &asset.Logo, &asset.LogoHash},
+		}
+
+		for _, img := range images {
+			if len(*img.data) == 0 {
+				continue
+			}
+
+			name, err := SaveImage(*img.data, dirpath)
+			*img.data = nil
+			if err != nil {
+				subLog.Error().Err(err).Str("Field", img.field).Msg("failed to save image")
+				continue
+			}
+
+			if *img.hash != name {
+				asset.RecordChange(img.field, *img.hash, name, "icons")
+				*img.hash = name
+				asset.Updated = true
+			}
+		}
+	}
+}
+
+// ChangedIconFiles returns the content addresses of icons and logos saved
+// during this run
+func ChangedIconFiles(assets []*Asset) []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, asset := range assets {
+		for _, field := range []string{"IconHash", "LogoHash"} {
+			change := asset.LastChange(field)
+			if change == nil || change.New == "" || seen[change.New] {
+				continue
+			}
+			seen[change.New] = true
+			names = append(names, change.New)
+		}
+	}
+	return names
+}
+
+// SetIconUrl updates the icon url of the asset. When the url changes the
+// saved icon is forgotten so it is downloaded again.
+func (asset *Asset) SetIconUrl(url, source string) {
+	setImageUrl(asset, "IconUrl", &asset.IconUrl, "IconHash", &asset.IconHash, url, source)
+}
+
+// SetLogoUrl updates the logo url of the asset. When the url changes the
+// saved logo is forgotten so it is downloaded again.
+func (asset *Asset) SetLogoUrl(url, source string) {
+	setImageUrl(asset, "LogoUrl", &asset.LogoUrl, "LogoHash", &asset.LogoHash, url, source)
+}
+
+func setImageUrl(asset *Asset, urlField string, dest *string, hashField string, hash *string, url, source string) {
+	if url == "" || *dest == url {
+		return
+	}
+
+	asset.RecordChange(urlField, *dest, url, source)
+	*dest = url
+
+	if *hash != "" {
+		asset.RecordChange(hashField, *hash, "", source)
+		*hash = ""
+	}
+
+	asset.Updated = true
+	asset.LastUpdated = time.Now().Unix()
+}
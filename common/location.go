@@ -0,0 +1,69 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+var usPostalCode = regexp.MustCompile(`^(\d{5})-?(\d{4})?$`)
+
+// Location is a normalized postal location
+type Location struct {
+	City       string
+	State      string
+	PostalCode string
+	Country    string
+}
+
+// NewLocation normalizes the components of a location: the city is title
+// cased, state and country codes are upper cased, and US postal codes are
+// formatted as 12345 or 12345-6789
+func NewLocation(city, state, postalCode, country string) *Location {
+	loc := &Location{
+		City:       cases.Title(language.AmericanEnglish).String(strings.Join(strings.Fields(city), " ")),
+		State:      strings.ToUpper(strings.TrimSpace(state)),
+		PostalCode: strings.ToUpper(strings.TrimSpace(postalCode)),
+		Country:    strings.ToUpper(strings.TrimSpace(country)),
+	}
+
+	if loc.Country == "US" {
+		if match := usPostalCode.FindStringSubmatch(loc.PostalCode); match != nil {
+			loc.PostalCode = match[1]
+			if match[2] != "" {
+				loc.PostalCode += "-" + match[2]
+			}
+		}
+	}
+
+	return loc
+}
+
+// String formats the location for display, e.g. "Cupertino, CA 95014, US".
+// Empty components are omitted.
+func (loc *Location) String() string {
+	region := strings.TrimSpace(loc.State + " " + loc.PostalCode)
+	parts := make([]string, 0, 3)
+	for _, part := range []string{loc.City, region, loc.Country} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,316 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rs/zerolog/log"
+)
+
+// PlanEntry is a single asset affected by an import
+type PlanEntry struct {
+	Ticker        string       `json:"ticker"`
+	Name          string       `json:"name"`
+	AssetType     AssetType    `json:"asset_type"`
+	CompositeFigi string       `json:"composite_figi"`
+	Reason        string       `json:"reason,omitempty"`
+	Changes       FieldChanges `json:"changes,omitempty"`
+}
+
+// Plan describes the changes an import makes to the asset database
+type Plan struct {
+	New          []*PlanEntry `json:"new"`
+	Renamed      []*PlanEntry `json:"renamed"`
+	Updated      []*PlanEntry `json:"updated"`
+	Delisted     []*PlanEntry `json:"delisted"`
+	Blacklisted  []*PlanEntry `json:"blacklisted"`
+	Held         []*PlanEntry `json:"held"`
+	Deduplicated []*PlanEntry `json:"deduplicated"`
+
+	// Sources holds the download summary of each source that provides one
+	Sources map[string]interface{} `json:"sources,omitempty"`
+}
+
+// NewPlan creates an empty plan
+func NewPlan() *Plan {
+	return &Plan{
+		New:          []*PlanEntry{},
+		Renamed:      []*PlanEntry{},
+		Updated:      []*PlanEntry{},
+		Delisted:     []*PlanEntry{},
+		Blacklisted:  []*PlanEntry{},
+		Held:         []*PlanEntry{},
+		Deduplicated: []*PlanEntry{},
+		Sources:      make(map[string]interface{}),
+	}
+}
+
+// AddSourceReport records the download summary of source `name`
+func (plan *Plan) AddSourceReport(name string, report interface{}) {
+	plan.Sources[name] = report
+}
+
+func newPlanEntry(asset *Asset, reason string) *PlanEntry {
+	return &PlanEntry{
+		Ticker:        asset.Ticker,
+		Name:          asset.Name,
+		AssetType:     asset.AssetType,
+		CompositeFigi: asset.CompositeFigi,
+		Reason:        reason,
+	}
+}
+
+// SnapshotAssets copies assets so their current values can be compared
+// against after the import modifies them
+func SnapshotAssets(assets []*Asset) map[string]*Asset {
+	snapshot := make(map[string]*Asset, len(assets))
+	for _, asset := range assets {
+		assetCopy := *asset
+		snapshot[asset.Ticker] = &assetCopy
+	}
+	return snapshot
+}
+
+// DiffAsset returns the fields that differ between `before` and `after`. The
+// source and time of each difference is taken from the last change recorded
+// for the field on `after`.
+func DiffAsset(before, after *Asset) FieldChanges {
+	fields := []struct {
+		name     string
+		old, new string
+	}{
+		{"Name", before.Name, after.Name},
+		{"Description", before.Description, after.Description},
+		{"PrimaryExchange", before.PrimaryExchange, after.PrimaryExchange},
+		{"AssetType", string(before.AssetType), string(after.AssetType)},
+		{"CompositeFigi", before.CompositeFigi, after.CompositeFigi},
+		{"ShareClassFigi", before.ShareClassFigi, after.ShareClassFigi},
+		{"CUSIP", before.CUSIP, after.CUSIP},
+		{"ISIN", before.ISIN, after.ISIN},
+		{"CIK", before.CIK, after.CIK},
+		{"ListingDate", before.ListingDate, after.ListingDate},
+		{"DelistingDate", before.DelistingDate, after.DelistingDate},
+		{"Industry", before.Industry, after.Industry},
+		{"Sector", before.Sector, after.Sector},
+		{"IconUrl", before.IconUrl, after.IconUrl},
+		{"LogoUrl", before.LogoUrl, after.LogoUrl},
+		{"IconHash", before.IconHash, after.IconHash},
+		{"LogoHash", before.LogoHash, after.LogoHash},
+		{"CorporateUrl", before.CorporateUrl, after.CorporateUrl},
+		{"HeadquartersLocation", before.HeadquartersLocation, after.HeadquartersLocation},
+		{"HeadquartersCity", before.HeadquartersCity, after.HeadquartersCity},
+		{"HeadquartersState", before.HeadquartersState, after.HeadquartersState},
+		{"HeadquartersPostalCode", before.HeadquartersPostalCode, after.HeadquartersPostalCode},
+		{"HeadquartersCountry", before.HeadquartersCountry, after.HeadquartersCountry},
+		{"PreviousTicker", before.PreviousTicker, after.PreviousTicker},
+		{"MarketCap", FormatFloat64(before.MarketCap), FormatFloat64(after.MarketCap)},
+		{"SicCode", before.SicCode, after.SicCode},
+		{"SicDescription", before.SicDescription, after.SicDescription},
+		{"TotalEmployees", FormatInt64(before.TotalEmployees), FormatInt64(after.TotalEmployees)},
+		{"PhoneNumber", before.PhoneNumber, after.PhoneNumber},
+		{"Address", before.Address, after.Address},
+		{"ShareClassSharesOutstanding", FormatInt64(before.ShareClassSharesOutstanding), FormatInt64(after.ShareClassSharesOutstanding)},
+		{"WeightedSharesOutstanding", FormatInt64(before.WeightedSharesOutstanding), FormatInt64(after.WeightedSharesOutstanding)},
+		{"SimilarTickers", strings.Join(before.SimilarTickers, ","), strings.Join(after.SimilarTickers, ",")},
+	}
+
+	diffs := make(FieldChanges, 0)
+	for _, field := range fields {
+		if field.old != field.new {
+			diff := &FieldChange{Field: field.name, Old: field.old, New: field.new}
+			if change := after.LastChange(field.name); change != nil {
+				diff.Source = change.Source
+				diff.Timestamp = change.Timestamp
+			}
+			diffs = append(diffs, diff)
+		}
+	}
+	return diffs
+}
+
+// AddNew records assets that are not yet in the database
+func (plan *Plan) AddNew(assets []*Asset) {
+	for _, asset := range assets {
+		plan.New = append(plan.New, newPlanEntry(asset, ""))
+	}
+}
+
+// AddRenamed records assets whose ticker changed
+func (plan *Plan) AddRenamed(renames []*TickerRename) {
+	for _, rename := range renames {
+		entry := newPlanEntry(rename.Asset, fmt.Sprintf("renamed from %s", rename.OldTicker))
+		plan.Renamed = append(plan.Renamed, entry)
+	}
+}
+
+// AddHeld records assets held back from the save by an enrichment stage
+func (plan *Plan) AddHeld(held []*HeldAsset) {
+	for _, item := range held {
+		plan.Held = append(plan.Held, newPlanEntry(item.Asset, item.Reason))
+	}
+}
+
+// AddBlacklisted records assets removed because they are in the blacklist
+func (plan *Plan) AddBlacklisted(assets []*Asset) {
+	for _, asset := range assets {
+		plan.Blacklisted = append(plan.Blacklisted, newPlanEntry(asset, "blacklisted"))
+	}
+}
+
+// AddDeduplicated records assets in `before` that were dropped because
+// another asset in `after` shares their composite figi
+func (plan *Plan) AddDeduplicated(before, after []*Asset) {
+	selected := make(map[string]*Asset, len(after))
+	for _, asset := range after {
+		selected[asset.CompositeFigi] = asset
+	}
+
+	for _, asset := range SubtractAssets(before, after) {
+		reason := "duplicate composite figi"
+		if keep, ok := selected[asset.CompositeFigi]; ok {
+			reason = fmt.Sprintf("duplicate of %s", keep.Ticker)
+		}
+		plan.Deduplicated = append(plan.Deduplicated, newPlanEntry(asset, reason))
+	}
+}
+
+// Finalize compares the final list of assets against the assets as they were
+// before the import and records delisted and updated assets
+func (plan *Plan) Finalize(previous map[string]*Asset, assets []*Asset) {
+	newTickers := make(map[string]bool, len(plan.New)+len(plan.Renamed))
+	for _, entry := range plan.New {
+		newTickers[entry.Ticker] = true
+	}
+	for _, entry := range plan.Renamed {
+		newTickers[entry.Ticker] = true
+	}
+
+	for _, asset := range assets {
+		if asset.DelistingDate != "" {
+			entry := newPlanEntry(asset, fmt.Sprintf("delisted %s", asset.DelistingDate))
+			plan.Delisted = append(plan.Delisted, entry)
+			continue
+		}
+
+		if newTickers[asset.Ticker] {
+			continue
+		}
+
+		before, ok := previous[asset.Ticker]
+		if !ok {
+			continue
+		}
+
+		if diffs := DiffAsset(before, asset); len(diffs) > 0 {
+			entry := newPlanEntry(asset, "")
+			entry.Changes = diffs
+			plan.Updated = append(plan.Updated, entry)
+		}
+	}
+
+	for _, entries := range [][]*PlanEntry{plan.New, plan.Renamed, plan.Updated, plan.Delisted, plan.Blacklisted, plan.Held, plan.Deduplicated} {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Ticker < entries[j].Ticker
+		})
+	}
+}
+
+// LogSummary logs the number of assets in each section of the plan
+func (plan *Plan) LogSummary() {
+	log.Info().
+		Int("New", len(plan.New)).
+		Int("Renamed", len(plan.Renamed)).
+		Int("Updated", len(plan.Updated)).
+		Int("Delisted", len(plan.Delisted)).
+		Int("Blacklisted", len(plan.Blacklisted)).
+		Int("Held", len(plan.Held)).
+		Int("Deduplicated", len(plan.Deduplicated)).
+		Msg("import plan")
+}
+
+// WriteJSON saves the plan as JSON to the file `fn`
+func (plan *Plan) WriteJSON(fn string) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		log.Error().Err(err).Msg("could not marshal plan to JSON")
+		return err
+	}
+
+	if err := os.WriteFile(fn, data, 0644); err != nil {
+		log.Error().Err(err).Str("FileName", fn).Msg("could not write plan")
+		return err
+	}
+
+	log.Info().Str("FileName", fn).Msg("wrote import plan")
+	return nil
+}
+
+// PrintTable writes a human readable version of the plan to `w`
+func (plan *Plan) PrintTable(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	sections := []struct {
+		title   string
+		entries []*PlanEntry
+	}{
+		{"NEW", plan.New},
+		{"RENAMED", plan.Renamed},
+		{"DELISTED", plan.Delisted},
+		{"BLACKLISTED", plan.Blacklisted},
+		{"HELD", plan.Held},
+		{"DEDUPLICATED", plan.Deduplicated},
+	}
+
+	for _, section := range sections {
+		fmt.Fprintf(tw, "%s (%d)\n", section.title, len(section.entries))
+		if len(section.entries) == 0 {
+			fmt.Fprintln(tw)
+			continue
+		}
+		fmt.Fprintln(tw, "TICKER\tNAME\tTYPE\tCOMPOSITE FIGI\tREASON")
+		for _, entry := range section.entries {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", entry.Ticker, truncate(entry.Name, 40), entry.AssetType, entry.CompositeFigi, entry.Reason)
+		}
+		fmt.Fprintln(tw)
+	}
+
+	fmt.Fprintf(tw, "UPDATED (%d)\n", len(plan.Updated))
+	if len(plan.Updated) > 0 {
+		fmt.Fprintln(tw, "TICKER\tFIELD\tOLD\tNEW\tSOURCE")
+		for _, entry := range plan.Updated {
+			for _, diff := range entry.Changes {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", entry.Ticker, diff.Field, truncate(diff.Old, 40), truncate(diff.New, 40), diff.Source)
+			}
+		}
+	}
+}
+
+// truncate shortens `s` to at most `n` characters for display
+func truncate(s string, n int) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-3]) + "..."
+}
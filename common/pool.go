@@ -0,0 +1,145 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/schollz/progressbar/v3"
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+)
+
+// WorkerPool runs provider lookups concurrently. At most Workers lookups are
+// in flight at once and each waits on Limiter (if set) before it starts, so
+// throughput is bounded by the provider's quota rather than its latency.
+type WorkerPool struct {
+	Name    string
+	Workers int
+	Limiter *rate.Limiter
+}
+
+// WorkResult is the outcome of processing a single item in a worker pool.
+// Err is the error returned by the work function or the context error for
+// items that were not started because the context was cancelled.
+type WorkResult[T any] struct {
+	Item T
+	Err  error
+}
+
+// NewWorkerPool creates a worker pool for `provider`. The number of workers
+// is read from <provider>.workers, falling back to the workers setting.
+func NewWorkerPool(provider string, limiter *rate.Limiter) *WorkerPool {
+	workers := viper.GetInt("workers")
+	if key := fmt.Sprintf("%s.workers", provider); viper.IsSet(key) {
+		workers = viper.GetInt(key)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &WorkerPool{
+		Name:    provider,
+		Workers: workers,
+		Limiter: limiter,
+	}
+}
+
+// RunPool calls `fn` for each item using the workers of `pool` and returns
+// a result for every item in input order. Once `ctx` is cancelled no new
+// items are started; items already in flight run to completion so their
+// results aren't lost.
+func RunPool[T any](ctx context.Context, pool *WorkerPool, items []T, fn func(context.Context, T) error) []*WorkResult[T] {
+	results := make([]*WorkResult[T], len(items))
+	for ii, item := range items {
+		results[ii] = &WorkResult[T]{Item: item}
+	}
+
+	if len(items) == 0 {
+		return results
+	}
+
+	var bar *progressbar.ProgressBar
+	if !viper.GetBool("display.hide_progress") {
+		bar = progressbar.Default(int64(len(items)))
+	}
+
+	workers := pool.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(items) {
+		workers = len(items)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for ii := 0; ii < workers; ii++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				results[idx].Err = runItem(ctx, pool.Limiter, results[idx].Item, fn)
+				if bar != nil {
+					bar.Add(1)
+				}
+			}
+		}()
+	}
+
+	for ii := range items {
+		if ctx.Err() != nil {
+			results[ii].Err = ctx.Err()
+			continue
+		}
+		jobs <- ii
+	}
+	close(jobs)
+	wg.Wait()
+
+	succeeded, failed, cancelled := 0, 0, 0
+	for _, result := range results {
+		switch {
+		case result.Err == nil:
+			succeeded++
+		case ctx.Err() != nil && errors.Is(result.Err, ctx.Err()):
+			cancelled++
+		default:
+			failed++
+		}
+	}
+
+	log.Info().Str("Pool", pool.Name).Int("Workers", workers).Int("Succeeded", succeeded).Int("Failed", failed).Int("Cancelled", cancelled).Msg("worker pool finished")
+	return results
+}
+
+func runItem[T any](ctx context.Context, limiter *rate.Limiter, item T, fn func(context.Context, T) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if limiter != nil {
+		if err := limiter.Wait(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+	}
+	return fn(context.WithoutCancel(ctx), item)
+}
@@ -0,0 +1,122 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestNewWorkerPool(t *testing.T) {
+	viper.Set("workers", 3)
+	viper.Set("yahoo.workers", 0)
+	defer viper.Reset()
+
+	if pool := NewWorkerPool("polygon", nil); pool.Workers != 3 {
+		t.Errorf("polygon workers = %d, want 3", pool.Workers)
+	}
+	if pool := NewWorkerPool("yahoo", nil); pool.Workers != 1 {
+		t.Errorf("yahoo workers = %d, want at least 1", pool.Workers)
+	}
+}
+
+func TestRunPool(t *testing.T) {
+	viper.Set("display.hide_progress", true)
+	defer viper.Reset()
+
+	errOdd := errors.New("odd")
+	items := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	var inFlight, maxInFlight int32
+	results := RunPool(context.Background(), &WorkerPool{Name: "test", Workers: 3}, items, func(ctx context.Context, item int) error {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			seen := atomic.LoadInt32(&maxInFlight)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxInFlight, seen, current) {
+				break
+			}
+		}
+		if item%2 == 1 {
+			return errOdd
+		}
+		return nil
+	})
+
+	if len(results) != len(items) {
+		t.Fatalf("got %d results, want %d", len(results), len(items))
+	}
+	for ii, result := range results {
+		if result.Item != items[ii] {
+			t.Errorf("result %d is for item %d", ii, result.Item)
+		}
+		if wantErr := ii%2 == 1; (result.Err != nil) != wantErr {
+			t.Errorf("item %d: err = %v", ii, result.Err)
+		}
+	}
+	if maxInFlight > 3 {
+		t.Errorf("%d items ran at once, want at most 3", maxInFlight)
+	}
+}
+
+func TestRunPoolCancelled(t *testing.T) {
+	viper.Set("display.hide_progress", true)
+	defer viper.Reset()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var calls int32
+	results := RunPool(ctx, &WorkerPool{Name: "test", Workers: 2}, []string{"a", "b", "c"}, func(ctx context.Context, item string) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+
+	if calls != 0 {
+		t.Errorf("%d items ran after cancel, want 0", calls)
+	}
+	for _, result := range results {
+		if !errors.Is(result.Err, context.Canceled) {
+			t.Errorf("item %s: err = %v, want context.Canceled", result.Item, result.Err)
+		}
+	}
+}
+
+func TestRunPoolFinishesInFlightItems(t *testing.T) {
+	viper.Set("display.hide_progress", true)
+	defer viper.Reset()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results := RunPool(ctx, &WorkerPool{Name: "test", Workers: 1}, []int{1, 2, 3}, func(ctx context.Context, item int) error {
+		if item == 1 {
+			cancel()
+		}
+		return ctx.Err()
+	})
+
+	if results[0].Err != nil {
+		t.Errorf("in-flight item saw cancellation: %v", results[0].Err)
+	}
+	for _, result := range results[1:] {
+		if !errors.Is(result.Err, context.Canceled) {
+			t.Errorf("item %d: err = %v, want context.Canceled", result.Item, result.Err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"sort"
+
+	"github.com/rs/zerolog/log"
+)
+
+// TickerRename links the previous ticker of an asset to its new ticker
+type TickerRename struct {
+	CompositeFigi string
+	OldTicker     string
+	NewTicker     string
+	Asset         *Asset
+}
+
+// DetectRenames matches assets that are no longer returned by any provider
+// (`removed`) with newly downloaded assets (`added`) that share the same
+// composite figi. A composite figi identifies the same security across ticker
+// changes, so each match is treated as a rename: the listing date and
+// enrichment data of the old asset is carried over to the new one and
+// PreviousTicker is set. Figi's that match more than one asset on either side
+// are ambiguous and skipped.
+//
+// Assets that can't be matched by composite figi (e.g. because the figi of
+// the old asset was never looked up) are matched using the ticker change
+// history in `tickerChanges`.
+func DetectRenames(removed, added []*Asset, tickerChanges []*TickerChange) []*TickerRename {
+	removedByFigi := groupByCompositeFigi(removed)
+	addedByFigi := groupByCompositeFigi(added)
+
+	// iterate in a fixed order so renames and logs are the same every run
+	figis := make([]string, 0, len(removedByFigi))
+	for figi := range removedByFigi {
+		figis = append(figis, figi)
+	}
+	sort.Strings(figis)
+
+	renames := make([]*TickerRename, 0)
+	matched := make(map[*Asset]bool)
+	for _, figi := range figis {
+		oldAssets := removedByFigi[figi]
+		newAssets, ok := addedByFigi[figi]
+		if !ok {
+			continue
+		}
+
+		if len(oldAssets) != 1 || len(newAssets) != 1 {
+			log.Warn().Str("CompositeFigi", figi).Int("NumRemoved", len(oldAssets)).Int("NumAdded", len(newAssets)).Msg("ambiguous ticker rename - skipping")
+			continue
+		}
+
+		oldAsset, newAsset := oldAssets[0], newAssets[0]
+		renames = append(renames, rename(oldAsset, newAsset, figi))
+		matched[oldAsset] = true
+		matched[newAsset] = true
+	}
+
+	removedByTicker := make(map[string]*Asset, len(removed))
+	for _, asset := range removed {
+		if !matched[asset] {
+			removedByTicker[asset.Ticker] = asset
+		}
+	}
+
+	changesByTicker := make(map[string][]*TickerChange)
+	for _, change := range tickerChanges {
+		changesByTicker[change.NewTicker] = append(changesByTicker[change.NewTicker], change)
+	}
+
+	for _, newAsset := range added {
+		if matched[newAsset] {
+			continue
+		}
+		for _, change := range changesByTicker[newAsset.Ticker] {
+			oldAsset, ok := removedByTicker[change.OldTicker]
+			if !ok || matched[oldAsset] {
+				continue
+			}
+			if !sameSecurity(change.CompositeFigi, oldAsset.CompositeFigi) || !sameSecurity(change.CompositeFigi, newAsset.CompositeFigi) {
+				continue
+			}
+			renames = append(renames, rename(oldAsset, newAsset, change.CompositeFigi))
+			matched[oldAsset] = true
+			matched[newAsset] = true
+			break
+		}
+	}
+
+	return renames
+}
+
+func rename(oldAsset, newAsset *Asset, figi string) *TickerRename {
+	carryOver(oldAsset, newAsset)
+	if figi == "" {
+		figi = newAsset.CompositeFigi
+	}
+	log.Info().Str("CompositeFigi", figi).Str("OldTicker", oldAsset.Ticker).Str("NewTicker", newAsset.Ticker).Msg("detected ticker rename")
+	return &TickerRename{
+		CompositeFigi: figi,
+		OldTicker:     oldAsset.Ticker,
+		NewTicker:     newAsset.Ticker,
+		Asset:         newAsset,
+	}
+}
+
+// sameSecurity reports whether two composite figi's may refer to the same
+// security; an unknown figi matches anything
+func sameSecurity(a, b string) bool {
+	return a == "" || b == "" || a == b
+}
+
+func groupByCompositeFigi(assets []*Asset) map[string][]*Asset {
+	figiMap := make(map[string][]*Asset)
+	for _, asset := range assets {
+		if asset.CompositeFigi == "" {
+			continue
+		}
+		figiMap[asset.CompositeFigi] = append(figiMap[asset.CompositeFigi], asset)
+	}
+	return figiMap
+}
+
+// carryOver copies the listing date and enrichment data of `from` to the
+// renamed asset `to`. Values already set on `to` (other than the listing
+// date, which is the date of the rename for the new ticker) are kept.
+func carryOver(from, to *Asset) {
+	const source = "rename"
+
+	to.RecordChange("PreviousTicker", to.PreviousTicker, from.Ticker, source)
+	to.PreviousTicker = from.Ticker
+
+	if from.ListingDate != "" && from.ListingDate != to.ListingDate {
+		to.RecordChange("ListingDate", to.ListingDate, from.ListingDate, source)
+		to.ListingDate = from.ListingDate
+	}
+
+	fields := []struct {
+		name     string
+		dest     *string
+		previous string
+	}{
+		{"Name", &to.Name, from.Name},
+		{"Description", &to.Description, from.Description},
+		{"CompositeFigi", &to.CompositeFigi, from.CompositeFigi},
+		{"ShareClassFigi", &to.ShareClassFigi, from.ShareClassFigi},
+		{"CUSIP", &to.CUSIP, from.CUSIP},
+		{"ISIN", &to.ISIN, from.ISIN},
+		{"CIK", &to.CIK, from.CIK},
+		{"Industry", &to.Industry, from.Industry},
+		{"Sector", &to.Sector, from.Sector},
+		{"IconUrl", &to.IconUrl, from.IconUrl},
+		{"LogoUrl", &to.LogoUrl, from.LogoUrl},
+		{"IconHash", &to.IconHash, from.IconHash},
+		{"LogoHash", &to.LogoHash, from.LogoHash},
+		{"CorporateUrl", &to.CorporateUrl, from.CorporateUrl},
+		{"HeadquartersLocation", &to.HeadquartersLocation, from.HeadquartersLocation},
+		{"HeadquartersCity", &to.HeadquartersCity, from.HeadquartersCity},
+		{"HeadquartersState", &to.HeadquartersState, from.HeadquartersState},
+		{"HeadquartersPostalCode", &to.HeadquartersPostalCode, from.HeadquartersPostalCode},
+		{"HeadquartersCountry", &to.HeadquartersCountry, from.HeadquartersCountry},
+		{"SicCode", &to.SicCode, from.SicCode},
+		{"SicDescription", &to.SicDescription, from.SicDescription},
+		{"PhoneNumber", &to.PhoneNumber, from.PhoneNumber},
+		{"Address", &to.Address, from.Address},
+	}
+
+	for _, field := range fields {
+		if *field.dest == "" && field.previous != "" {
+			to.RecordChange(field.name, "", field.previous, source)
+			*field.dest = field.previous
+		}
+	}
+
+	if to.MarketCap == 0 && from.MarketCap != 0 {
+		to.RecordChange("MarketCap", "", FormatFloat64(from.MarketCap), source)
+		to.MarketCap = from.MarketCap
+	}
+
+	counts := []struct {
+		name     string
+		dest     *int64
+		previous int64
+	}{
+		{"TotalEmployees", &to.TotalEmployees, from.TotalEmployees},
+		{"ShareClassSharesOutstanding", &to.ShareClassSharesOutstanding, from.ShareClassSharesOutstanding},
+		{"WeightedSharesOutstanding", &to.WeightedSharesOutstanding, from.WeightedSharesOutstanding},
+	}
+
+	for _, field := range counts {
+		if *field.dest == 0 && field.previous != 0 {
+			to.RecordChange(field.name, "", FormatInt64(field.previous), source)
+			*field.dest = field.previous
+		}
+	}
+
+	if len(to.SimilarTickers) == 0 {
+		to.SimilarTickers = from.SimilarTickers
+	}
+
+	// details were fetched for the old ticker; don't fetch them again
+	if to.PolygonDetailAge < from.PolygonDetailAge {
+		to.PolygonDetailAge = from.PolygonDetailAge
+	}
+}
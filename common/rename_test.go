@@ -0,0 +1,121 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"testing"
+)
+
+func TestDetectRenamesByCompositeFigi(t *testing.T) {
+	removed := []*Asset{
+		{Ticker: "FB", CompositeFigi: "BBG000MM2P62", ListingDate: "2012-05-18", Name: "Meta Platforms", CIK: "0001326801", MarketCap: 5e11, PolygonDetailAge: 100},
+		{Ticker: "GONE", CompositeFigi: "BBG000B9XRY4"},
+	}
+	added := []*Asset{
+		{Ticker: "META", CompositeFigi: "BBG000MM2P62", ListingDate: "2022-06-09", Name: "Meta"},
+		{Ticker: "NEW", CompositeFigi: "BBG000BPH459"},
+	}
+
+	renames := DetectRenames(removed, added, nil)
+	if len(renames) != 1 {
+		t.Fatalf("got %d renames, want 1", len(renames))
+	}
+
+	rename := renames[0]
+	if rename.OldTicker != "FB" || rename.NewTicker != "META" || rename.CompositeFigi != "BBG000MM2P62" {
+		t.Errorf("rename = %+v, want FB -> META", rename)
+	}
+
+	meta := added[0]
+	if meta.PreviousTicker != "FB" {
+		t.Errorf("PreviousTicker = %q, want FB", meta.PreviousTicker)
+	}
+	if meta.ListingDate != "2012-05-18" {
+		t.Errorf("ListingDate = %q, want the listing date of the old ticker", meta.ListingDate)
+	}
+	if meta.Name != "Meta" {
+		t.Errorf("Name = %q, want the name of the new ticker kept", meta.Name)
+	}
+	if meta.CIK != "0001326801" || meta.MarketCap != 5e11 || meta.PolygonDetailAge != 100 {
+		t.Errorf("enrichment not carried over: CIK=%q MarketCap=%v PolygonDetailAge=%d", meta.CIK, meta.MarketCap, meta.PolygonDetailAge)
+	}
+	if meta.LastChange("PreviousTicker") == nil {
+		t.Error("PreviousTicker change was not recorded")
+	}
+}
+
+func TestDetectRenamesSkipsAmbiguousFigi(t *testing.T) {
+	removed := []*Asset{
+		{Ticker: "OLD1", CompositeFigi: "BBG000B9XRY4"},
+		{Ticker: "OLD2", CompositeFigi: "BBG000B9XRY4"},
+	}
+	added := []*Asset{
+		{Ticker: "NEW", CompositeFigi: "BBG000B9XRY4"},
+	}
+
+	if renames := DetectRenames(removed, added, nil); len(renames) != 0 {
+		t.Errorf("got %d renames for an ambiguous figi, want 0", len(renames))
+	}
+	if added[0].PreviousTicker != "" {
+		t.Errorf("PreviousTicker = %q, want it unset", added[0].PreviousTicker)
+	}
+}
+
+func TestDetectRenamesByTickerChange(t *testing.T) {
+	removed := []*Asset{
+		{Ticker: "OLD", ListingDate: "2001-01-02"},
+		{Ticker: "OTHER", CompositeFigi: "BBG000BPH459"},
+	}
+	added := []*Asset{
+		{Ticker: "NEW", CompositeFigi: "BBG000B9XRY4"},
+		{Ticker: "NEW2", CompositeFigi: "BBG000B9Y5X2"},
+	}
+	changes := []*TickerChange{
+		{CompositeFigi: "BBG000B9XRY4", OldTicker: "OLD", NewTicker: "NEW", Date: "2022-01-03"},
+		// the figi of OTHER doesn't match the change so it isn't linked
+		{CompositeFigi: "BBG000B9Y5X2", OldTicker: "OTHER", NewTicker: "NEW2", Date: "2022-01-03"},
+	}
+
+	renames := DetectRenames(removed, added, changes)
+	if len(renames) != 1 {
+		t.Fatalf("got %d renames, want 1", len(renames))
+	}
+	if renames[0].OldTicker != "OLD" || renames[0].NewTicker != "NEW" || renames[0].CompositeFigi != "BBG000B9XRY4" {
+		t.Errorf("rename = %+v, want OLD -> NEW", renames[0])
+	}
+	if added[0].ListingDate != "2001-01-02" {
+		t.Errorf("ListingDate = %q, want 2001-01-02", added[0].ListingDate)
+	}
+}
+
+func TestDetectRenamesOrder(t *testing.T) {
+	figis := []string{"BBG000BPH459", "BBG000B9XRY4", "BBG000BLNNH6", "BBG000B9Y5X2"}
+	removed := make([]*Asset, 0, len(figis))
+	added := make([]*Asset, 0, len(figis))
+	for ii, figi := range figis {
+		removed = append(removed, &Asset{Ticker: string(rune('A' + ii)), CompositeFigi: figi})
+		added = append(added, &Asset{Ticker: string(rune('A'+ii)) + "X", CompositeFigi: figi})
+	}
+
+	renames := DetectRenames(removed, added, nil)
+	if len(renames) != len(figis) {
+		t.Fatalf("got %d renames, want %d", len(renames), len(figis))
+	}
+	for ii := 1; ii < len(renames); ii++ {
+		if renames[ii-1].CompositeFigi > renames[ii].CompositeFigi {
+			t.Fatalf("renames are not ordered by composite figi: %s before %s", renames[ii-1].CompositeFigi, renames[ii].CompositeFigi)
+		}
+	}
+}
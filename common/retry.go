@@ -0,0 +1,218 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// RetryPolicy describes how requests to a data provider are retried. Failed
+// requests are retried with exponential backoff and jitter, or after the
+// delay requested by the server in the Retry-After header.
+type RetryPolicy struct {
+	Provider      string
+	MaxAttempts   int
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	MaxRetryAfter time.Duration
+}
+
+// NewRetryPolicy reads the retry policy for `provider` from the config. The
+// retry.* settings apply to every provider; <provider>.max_attempts
+// overrides the number of attempts for a single provider.
+func NewRetryPolicy(provider string) *RetryPolicy {
+	policy := &RetryPolicy{
+		Provider:      provider,
+		MaxAttempts:   viper.GetInt("retry.max_attempts"),
+		BaseDelay:     viper.GetDuration("retry.base_delay"),
+		MaxDelay:      viper.GetDuration("retry.max_delay"),
+		MaxRetryAfter: viper.GetDuration("retry.max_retry_after"),
+	}
+
+	if key := fmt.Sprintf("%s.max_attempts", provider); viper.IsSet(key) {
+		policy.MaxAttempts = viper.GetInt(key)
+	}
+
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.MaxDelay < policy.BaseDelay {
+		policy.MaxDelay = policy.BaseDelay
+	}
+	if policy.MaxRetryAfter < policy.MaxDelay {
+		policy.MaxRetryAfter = policy.MaxDelay
+	}
+
+	return policy
+}
+
+// Apply configures `client` to retry requests according to the policy.
+// Backoff between attempts, including after network errors, is capped at
+// MaxDelay; only a Retry-After sent by the server may wait longer, up to
+// MaxRetryAfter.
+func (policy *RetryPolicy) Apply(client *resty.Client) *resty.Client {
+	return client.
+		SetRetryCount(policy.MaxAttempts - 1).
+		SetRetryWaitTime(policy.BaseDelay).
+		SetRetryMaxWaitTime(policy.MaxDelay).
+		AddRetryCondition(func(resp *resty.Response, err error) bool {
+			statusCode := 0
+			if resp != nil {
+				statusCode = resp.StatusCode()
+			}
+			return IsRetryable(statusCode, err)
+		}).
+		SetRetryAfter(func(client *resty.Client, resp *resty.Response) (time.Duration, error) {
+			delay, err := policy.Delay(resp.Request.Attempt, resp.Header())
+			if err != nil || delay <= policy.MaxDelay {
+				return delay, err
+			}
+			// resty caps every wait at MaxDelay; wait out the rest of a
+			// longer Retry-After here
+			if err := sleepContext(resp.Request.Context(), delay-policy.MaxDelay); err != nil {
+				return 0, err
+			}
+			return policy.MaxDelay, nil
+		}).
+		AddRetryHook(func(resp *resty.Response, err error) {
+			// resty also calls hooks after the final attempt
+			if resp != nil && resp.Request.Attempt >= policy.MaxAttempts {
+				return
+			}
+			subLog := log.Warn().Str("Provider", policy.Provider).Err(err)
+			if resp != nil {
+				subLog = subLog.Int("StatusCode", resp.StatusCode()).Int("Attempt", resp.Request.Attempt)
+			}
+			subLog.Int("MaxAttempts", policy.MaxAttempts).Msg("request failed - retrying")
+		})
+}
+
+// Delay returns how long to wait before retrying after the `attempt`-th
+// failed attempt. If the server sent a Retry-After header it is honored; a
+// Retry-After longer than MaxRetryAfter is treated as a fatal error.
+// Otherwise the delay grows exponentially from BaseDelay up to MaxDelay with
+// random jitter so concurrent clients don't retry in lock-step.
+func (policy *RetryPolicy) Delay(attempt int, header http.Header) (time.Duration, error) {
+	if retryAfter, ok := ParseRetryAfter(header.Get("Retry-After"), time.Now()); ok {
+		if retryAfter > policy.MaxRetryAfter {
+			return 0, fmt.Errorf("server requested a retry after %s which is longer than the allowed %s", retryAfter, policy.MaxRetryAfter)
+		}
+		return retryAfter, nil
+	}
+
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	delay := policy.MaxDelay
+	if shift := attempt - 1; shift < 32 {
+		if backoff := policy.BaseDelay << shift; backoff > 0 && backoff < delay {
+			delay = backoff
+		}
+	}
+
+	// equal jitter: wait between half and all of the computed delay
+	half := int64(delay / 2)
+	if half > 0 {
+		delay = time.Duration(half + rand.Int63n(half+1))
+	}
+	return delay, nil
+}
+
+// sleepContext waits for `delay` or until `ctx` is cancelled
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// IsRetryable classifies the outcome of a request. Rate limiting (429),
+// request timeouts (408), and server errors (5xx other than 501) are
+// retryable, as are network errors such as timeouts and refused or reset
+// connections. Other client errors, cancelled requests and errors that
+// aren't network related (e.g. a request missing from a replay) are fatal.
+func IsRetryable(statusCode int, err error) bool {
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return false
+		}
+		// *url.Error satisfies net.Error itself; classify what it wraps
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return true
+		}
+		var netErr net.Error
+		return errors.As(err, &netErr)
+	}
+
+	switch {
+	case statusCode == http.StatusTooManyRequests, statusCode == http.StatusRequestTimeout:
+		return true
+	case statusCode == http.StatusNotImplemented:
+		return false
+	case statusCode >= 500:
+		return true
+	default:
+		return false
+	}
+}
+
+// ParseRetryAfter parses the value of a Retry-After header, which is either
+// a number of seconds or an HTTP date
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if tm, err := http.ParseTime(value); err == nil {
+		delay := tm.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+
+	return 0, false
+}
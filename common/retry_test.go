@@ -0,0 +1,193 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/spf13/viper"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		err        error
+		want       bool
+	}{
+		{"ok", http.StatusOK, nil, false},
+		{"not found", http.StatusNotFound, nil, false},
+		{"rate limited", http.StatusTooManyRequests, nil, true},
+		{"request timeout", http.StatusRequestTimeout, nil, true},
+		{"server error", http.StatusInternalServerError, nil, true},
+		{"bad gateway", http.StatusBadGateway, nil, true},
+		{"not implemented", http.StatusNotImplemented, nil, false},
+		{"cancelled", 0, &url.Error{Op: "Get", URL: "http://example.com", Err: context.Canceled}, false},
+		{"connection reset", 0, &url.Error{Op: "Get", URL: "http://example.com", Err: &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}}, true},
+		{"unexpected eof", 0, &url.Error{Op: "Get", URL: "http://example.com", Err: io.ErrUnexpectedEOF}, true},
+		{"not recorded", 0, &url.Error{Op: "Get", URL: "http://example.com", Err: errors.New("no recording found for request")}, false},
+	}
+
+	for _, tt := range tests {
+		if got := IsRetryable(tt.statusCode, tt.err); got != tt.want {
+			t.Errorf("%s: IsRetryable(%d, %v) = %t, want %t", tt.name, tt.statusCode, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		delay time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{" 5 ", 5 * time.Second, true},
+		{"-1", 0, false},
+		{"Wed, 01 Jun 2022 12:00:30 GMT", 30 * time.Second, true},
+		{"Wed, 01 Jun 2022 11:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+
+	for _, tt := range tests {
+		delay, ok := ParseRetryAfter(tt.value, now)
+		if delay != tt.delay || ok != tt.ok {
+			t.Errorf("ParseRetryAfter(%q) = %s, %t, want %s, %t", tt.value, delay, ok, tt.delay, tt.ok)
+		}
+	}
+}
+
+func TestNewRetryPolicy(t *testing.T) {
+	viper.Set("retry.max_attempts", 4)
+	viper.Set("retry.base_delay", time.Second)
+	viper.Set("retry.max_delay", 100*time.Millisecond)
+	viper.Set("retry.max_retry_after", time.Millisecond)
+	viper.Set("polygon.max_attempts", 0)
+	defer viper.Reset()
+
+	policy := NewRetryPolicy("tiingo")
+	if policy.MaxAttempts != 4 {
+		t.Errorf("MaxAttempts = %d, want 4", policy.MaxAttempts)
+	}
+	if policy.MaxDelay != time.Second {
+		t.Errorf("MaxDelay = %s, want it raised to the base delay", policy.MaxDelay)
+	}
+	if policy.MaxRetryAfter != time.Second {
+		t.Errorf("MaxRetryAfter = %s, want it raised to the max delay", policy.MaxRetryAfter)
+	}
+
+	if policy := NewRetryPolicy("polygon"); policy.MaxAttempts != 1 {
+		t.Errorf("polygon MaxAttempts = %d, want 1", policy.MaxAttempts)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	policy := &RetryPolicy{
+		MaxAttempts:   5,
+		BaseDelay:     100 * time.Millisecond,
+		MaxDelay:      time.Second,
+		MaxRetryAfter: time.Minute,
+	}
+
+	for attempt, ceiling := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		3:  400 * time.Millisecond,
+		5:  time.Second,
+		40: time.Second,
+	} {
+		delay, err := policy.Delay(attempt, http.Header{})
+		if err != nil {
+			t.Fatalf("Delay(%d) returned %v", attempt, err)
+		}
+		if delay < ceiling/2 || delay > ceiling {
+			t.Errorf("Delay(%d) = %s, want between %s and %s", attempt, delay, ceiling/2, ceiling)
+		}
+	}
+
+	header := http.Header{}
+	header.Set("Retry-After", "30")
+	if delay, err := policy.Delay(1, header); err != nil || delay != 30*time.Second {
+		t.Errorf("Delay with Retry-After 30 = %s, %v, want 30s", delay, err)
+	}
+
+	header.Set("Retry-After", "3600")
+	if _, err := policy.Delay(1, header); err == nil {
+		t.Error("Delay with Retry-After longer than MaxRetryAfter should fail")
+	}
+}
+
+func TestApplyRetriesServerErrors(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	policy := &RetryPolicy{
+		Provider:      "test",
+		MaxAttempts:   3,
+		BaseDelay:     time.Millisecond,
+		MaxDelay:      5 * time.Millisecond,
+		MaxRetryAfter: 5 * time.Millisecond,
+	}
+	resp, err := policy.Apply(resty.New()).R().Get(server.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode() != http.StatusOK || calls != 3 {
+		t.Errorf("status = %d after %d calls, want 200 after 3", resp.StatusCode(), calls)
+	}
+}
+
+func TestApplyDoesNotRetryClientErrors(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	policy := &RetryPolicy{
+		Provider:      "test",
+		MaxAttempts:   3,
+		BaseDelay:     time.Millisecond,
+		MaxDelay:      time.Millisecond,
+		MaxRetryAfter: time.Millisecond,
+	}
+	resp, err := policy.Apply(resty.New()).R().Get(server.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode() != http.StatusNotFound || calls != 1 {
+		t.Errorf("status = %d after %d calls, want 404 after 1", resp.StatusCode(), calls)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// Source is a provider of the base list of tradeable assets (the universe)
+type Source interface {
+	// Name is the key used to reference the source in the config file
+	Name() string
+
+	// FetchAssets downloads the list of assets from the provider. The
+	// download stops with an error when `ctx` is cancelled.
+	FetchAssets(ctx context.Context) ([]*Asset, error)
+
+	// MinAssets is the minimum number of assets the provider is expected to
+	// return; fewer assets is treated as a provider failure
+	MinAssets() int
+}
+
+// SourceReporter is implemented by sources that summarize their last
+// download, e.g. how many rows were filtered out. The summary is added to the
+// import plan.
+type SourceReporter interface {
+	Report() interface{}
+}
+
+// SourceConfig describes a single entry in the `sources` list of the config file
+type SourceConfig struct {
+	Name      string `mapstructure:"name"`
+	MinAssets int    `mapstructure:"min_assets"`
+	Disabled  bool   `mapstructure:"disabled"`
+}
+
+// DefaultSources is the list of sources used when none are configured
+var DefaultSources = []string{"polygon", "tiingo"}
+
+var (
+	sourceMu       sync.RWMutex
+	sourceRegistry = make(map[string]Source)
+)
+
+// RegisterSource makes a source available by name to the import pipeline. It
+// is typically called from the init function of the provider package.
+func RegisterSource(source Source) {
+	sourceMu.Lock()
+	defer sourceMu.Unlock()
+
+	name := source.Name()
+	if _, ok := sourceRegistry[name]; ok {
+		panic(fmt.Sprintf("source %s registered twice", name))
+	}
+	sourceRegistry[name] = source
+}
+
+// LookupSource returns the registered source with the given name
+func LookupSource(name string) (Source, error) {
+	sourceMu.RLock()
+	defer sourceMu.RUnlock()
+
+	source, ok := sourceRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown source '%s'", name)
+	}
+	return source, nil
+}
+
+// RegisteredSources returns the names of all registered sources in sorted order
+func RegisteredSources() []string {
+	sourceMu.RLock()
+	defer sourceMu.RUnlock()
+
+	names := make([]string, 0, len(sourceRegistry))
+	for name := range sourceRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ConfiguredSources reads the ordered list of sources from the `sources` key
+// of the config file. Disabled sources are omitted. If no sources are
+// configured DefaultSources is used.
+func ConfiguredSources() ([]*SourceConfig, error) {
+	var sourceConfigs []*SourceConfig
+	if err := viper.UnmarshalKey("sources", &sourceConfigs); err != nil {
+		return nil, err
+	}
+
+	if len(sourceConfigs) == 0 {
+		sourceConfigs = make([]*SourceConfig, len(DefaultSources))
+		for ii, name := range DefaultSources {
+			sourceConfigs[ii] = &SourceConfig{Name: name}
+		}
+	}
+
+	enabled := make([]*SourceConfig, 0, len(sourceConfigs))
+	for _, sourceConfig := range sourceConfigs {
+		if sourceConfig.Disabled {
+			continue
+		}
+		if _, err := LookupSource(sourceConfig.Name); err != nil {
+			return nil, err
+		}
+		enabled = append(enabled, sourceConfig)
+	}
+
+	return enabled, nil
+}
+
+// MinAssetsFor returns the minimum number of assets required from source;
+// a value set in the config file overrides the source's default
+func (sourceConfig *SourceConfig) MinAssetsFor(source Source) int {
+	if sourceConfig.MinAssets > 0 {
+		return sourceConfig.MinAssets
+	}
+	return source.MinAssets()
+}
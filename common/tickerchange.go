@@ -0,0 +1,161 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// TickerChange records that the security identified by CompositeFigi began
+// trading as NewTicker (previously OldTicker) on Date
+type TickerChange struct {
+	CompositeFigi string `json:"composite_figi" parquet:"name=composite_figi, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	OldTicker     string `json:"old_ticker" parquet:"name=old_ticker, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	NewTicker     string `json:"new_ticker" parquet:"name=new_ticker, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Date          string `json:"date" parquet:"name=date, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Source        string `json:"source" parquet:"name=source, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+}
+
+// TickerChangesFromRenames converts renames detected during an import into
+// ticker changes effective on `date`
+func TickerChangesFromRenames(renames []*TickerRename, date string) []*TickerChange {
+	changes := make([]*TickerChange, 0, len(renames))
+	for _, rename := range renames {
+		changes = append(changes, &TickerChange{
+			CompositeFigi: rename.CompositeFigi,
+			OldTicker:     rename.OldTicker,
+			NewTicker:     rename.NewTicker,
+			Date:          date,
+			Source:        "import-tickers",
+		})
+	}
+	return changes
+}
+
+// MergeTickerChanges combines two lists of ticker changes. A change is
+// identified by its composite figi, old ticker, and new ticker; when both
+// lists contain the same change the entry in `first` is kept. The result is
+// sorted by date.
+func MergeTickerChanges(first, second []*TickerChange) []*TickerChange {
+	seen := make(map[string]bool, len(first)+len(second))
+	merged := make([]*TickerChange, 0, len(first)+len(second))
+	for _, changes := range [][]*TickerChange{first, second} {
+		for _, change := range changes {
+			key := change.CompositeFigi + ":" + change.OldTicker + ":" + change.NewTicker
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			merged = append(merged, change)
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].Date == merged[j].Date {
+			return merged[i].NewTicker < merged[j].NewTicker
+		}
+		return merged[i].Date < merged[j].Date
+	})
+
+	return merged
+}
+
+// ReadTickerChangesFromParquet loads ticker changes from the parquet file
+// `fn`. A missing file is treated as an empty history.
+func ReadTickerChangesFromParquet(fn string) []*TickerChange {
+	if _, err := os.Stat(fn); errors.Is(err, os.ErrNotExist) {
+		log.Info().Str("FileName", fn).Msg("no ticker change history found")
+		return []*TickerChange{}
+	}
+
+	fr, err := local.NewLocalFileReader(fn)
+	if err != nil {
+		log.Error().Err(err).Str("FileName", fn).Msg("can't open file")
+		return []*TickerChange{}
+	}
+	defer fr.Close()
+
+	pr, err := reader.NewParquetReader(fr, new(TickerChange), 4)
+	if err != nil {
+		log.Error().Err(err).Msg("can't create parquet reader")
+		return []*TickerChange{}
+	}
+	defer pr.ReadStop()
+
+	num := int(pr.GetNumRows())
+	changes := make([]*TickerChange, num)
+	rows := make([]TickerChange, num)
+	if err = pr.Read(&rows); err != nil {
+		log.Error().Err(err).Msg("parquet read error")
+		return []*TickerChange{}
+	}
+
+	for ii := range rows {
+		changes[ii] = &rows[ii]
+	}
+
+	log.Info().Str("FileName", fn).Int("NumChanges", len(changes)).Msg("loaded ticker changes")
+	return changes
+}
+
+// SaveTickerChangesToParquet writes ticker changes to the parquet file `fn`
+func SaveTickerChangesToParquet(changes []*TickerChange, fn string) error {
+	var err error
+
+	fh, err := local.NewLocalFileWriter(fn)
+	if err != nil {
+		log.Error().Err(err).Str("FileName", fn).Msg("cannot create local file")
+		return err
+	}
+	defer fh.Close()
+
+	pw, err := writer.NewParquetWriter(fh, new(TickerChange), 4)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("Parquet write failed")
+		return err
+	}
+
+	pw.RowGroupSize = 128 * 1024 * 1024 // 128M
+	pw.PageSize = 8 * 1024              // 8k
+	pw.CompressionType = parquet.CompressionCodec_GZIP
+
+	for _, change := range changes {
+		if err = pw.Write(change); err != nil {
+			log.Error().
+				Err(err).
+				Str("CompositeFigi", change.CompositeFigi).
+				Str("NewTicker", change.NewTicker).
+				Msg("Parquet write failed for ticker change")
+		}
+	}
+
+	if err = pw.WriteStop(); err != nil {
+		log.Error().Err(err).Msg("Parquet write failed")
+		return err
+	}
+
+	log.Info().Int("NumChanges", len(changes)).Str("FileName", fn).Msg("ticker changes parquet write finished")
+	return nil
+}
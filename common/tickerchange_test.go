@@ -0,0 +1,84 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeTickerChanges(t *testing.T) {
+	first := []*TickerChange{
+		{CompositeFigi: "BBG000MM2P62", OldTicker: "FB", NewTicker: "META", Date: "2022-06-09", Source: "polygon"},
+		{CompositeFigi: "BBG000BPH459", OldTicker: "MSFT1", NewTicker: "MSFT", Date: "2001-01-02", Source: "polygon"},
+	}
+	second := []*TickerChange{
+		{CompositeFigi: "BBG000MM2P62", OldTicker: "FB", NewTicker: "META", Date: "2022-06-10", Source: "import-tickers"},
+		{CompositeFigi: "BBG000B9XRY4", OldTicker: "AAPL1", NewTicker: "AAPL", Date: "2001-01-02", Source: "import-tickers"},
+	}
+
+	merged := MergeTickerChanges(first, second)
+	want := []struct {
+		newTicker string
+		source    string
+	}{
+		{"AAPL", "import-tickers"},
+		{"MSFT", "polygon"},
+		{"META", "polygon"},
+	}
+
+	if len(merged) != len(want) {
+		t.Fatalf("got %d changes, want %d", len(merged), len(want))
+	}
+	for ii, change := range merged {
+		if change.NewTicker != want[ii].newTicker || change.Source != want[ii].source {
+			t.Errorf("change %d = %s from %s, want %s from %s", ii, change.NewTicker, change.Source, want[ii].newTicker, want[ii].source)
+		}
+	}
+}
+
+func TestTickerChangesFromRenames(t *testing.T) {
+	renames := []*TickerRename{
+		{CompositeFigi: "BBG000MM2P62", OldTicker: "FB", NewTicker: "META"},
+	}
+
+	changes := TickerChangesFromRenames(renames, "2022-06-09")
+	if len(changes) != 1 {
+		t.Fatalf("got %d changes, want 1", len(changes))
+	}
+	change := changes[0]
+	if change.OldTicker != "FB" || change.NewTicker != "META" || change.Date != "2022-06-09" || change.Source != "import-tickers" {
+		t.Errorf("change = %+v", change)
+	}
+}
+
+func TestTickerChangesParquet(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "ticker_changes.parquet")
+	if changes := ReadTickerChangesFromParquet(fn); len(changes) != 0 {
+		t.Fatalf("read %d changes from a missing file, want 0", len(changes))
+	}
+
+	changes := []*TickerChange{
+		{CompositeFigi: "BBG000MM2P62", OldTicker: "FB", NewTicker: "META", Date: "2022-06-09", Source: "polygon"},
+	}
+	if err := SaveTickerChangesToParquet(changes, fn); err != nil {
+		t.Fatalf("SaveTickerChangesToParquet failed: %v", err)
+	}
+
+	read := ReadTickerChangesFromParquet(fn)
+	if len(read) != 1 || *read[0] != *changes[0] {
+		t.Errorf("read %+v, want %+v", read, changes)
+	}
+}
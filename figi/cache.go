@@ -0,0 +1,261 @@
+package figi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultCachePositiveTTL is how long a query that matched is cached
+	// when openfigi.cache_ttl is not set
+	DefaultCachePositiveTTL = 30 * 24 * time.Hour
+
+	// DefaultCacheNegativeTTL is how long a query that matched nothing is
+	// cached when openfigi.cache_negative_ttl is not set
+	DefaultCacheNegativeTTL = 7 * 24 * time.Hour
+
+	// noMatchWarning is the warning OpenFIGI returns for a query that
+	// matched nothing
+	noMatchWarning = "No identifier found"
+)
+
+// CacheEntry is the cached response of a single mapping query. Fetched is
+// the unix time the response was received.
+type CacheEntry struct {
+	IdType       string           `json:"idType"`
+	IdValue      string           `json:"idValue"`
+	ExchangeCode string           `json:"exchCode"`
+	Data         []*OpenFigiAsset `json:"data,omitempty"`
+	Warning      string           `json:"warning,omitempty"`
+	Fetched      int64            `json:"fetched"`
+}
+
+// CacheStats counts the entries of a cache. Negative entries are queries
+// that matched nothing; expired entries are also counted
+// as positive or negative.
+type CacheStats struct {
+	Entries  int
+	Positive int
+	Negative int
+	Expired  int
+}
+
+// Cache stores mapping responses on disk keyed by (idType, idValue,
+// exchCode) so repeated runs don't query OpenFIGI again for the same
+// identifiers. Matches expire after PositiveTTL and queries that matched
+// nothing after NegativeTTL.
+type Cache struct {
+	fn          string
+	PositiveTTL time.Duration
+	NegativeTTL time.Duration
+	entries     map[string]*CacheEntry
+	dirty       bool
+}
+
+func cacheKey(idType, idValue, exchangeCode string) string {
+	return strings.Join([]string{idType, idValue, exchangeCode}, "|")
+}
+
+// LoadCache opens the cache in openfigi.cache_file with the TTLs in
+// openfigi.cache_ttl and openfigi.cache_negative_ttl. It returns nil if
+// openfigi.cache_file is empty.
+func LoadCache() *Cache {
+	fn := viper.GetString("openfigi.cache_file")
+	if fn == "" {
+		return nil
+	}
+
+	positiveTTL := DefaultCachePositiveTTL
+	if viper.IsSet("openfigi.cache_ttl") {
+		positiveTTL = viper.GetDuration("openfigi.cache_ttl")
+	}
+	negativeTTL := DefaultCacheNegativeTTL
+	if viper.IsSet("openfigi.cache_negative_ttl") {
+		negativeTTL = viper.GetDuration("openfigi.cache_negative_ttl")
+	}
+
+	return OpenCache(fn, positiveTTL, negativeTTL)
+}
+
+// OpenCache reads the cache stored in `fn`. A missing or unreadable file
+// results in an empty cache.
+func OpenCache(fn string, positiveTTL, negativeTTL time.Duration) *Cache {
+	cache := &Cache{
+		fn:          fn,
+		PositiveTTL: positiveTTL,
+		NegativeTTL: negativeTTL,
+		entries:     make(map[string]*CacheEntry),
+	}
+
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warn().Err(err).Str("FileName", fn).Msg("could not read openfigi cache")
+		}
+		return cache
+	}
+
+	entries := make([]*CacheEntry, 0)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		log.Warn().Err(err).Str("FileName", fn).Msg("ignoring invalid openfigi cache")
+		return cache
+	}
+
+	for _, entry := range entries {
+		if len(entry.Data) == 0 && !strings.HasPrefix(entry.Warning, noMatchWarning) {
+			continue
+		}
+		cache.entries[cacheKey(entry.IdType, entry.IdValue, entry.ExchangeCode)] = entry
+	}
+
+	return cache
+}
+
+// negative returns true if the entry is for a query that matched nothing
+func (entry *CacheEntry) negative() bool {
+	return len(entry.Data) == 0
+}
+
+// expired returns true if the entry is older than its TTL at `now`
+func (cache *Cache) expired(entry *CacheEntry, now time.Time) bool {
+	ttl := cache.PositiveTTL
+	if entry.negative() {
+		ttl = cache.NegativeTTL
+	}
+	return now.Sub(time.Unix(entry.Fetched, 0)) > ttl
+}
+
+// Get returns the cached response for `query` if it hasn't expired
+func (cache *Cache) Get(query *OpenFigiQuery) (*MappingResponse, bool) {
+	entry, ok := cache.entries[cacheKey(query.IdType, query.IdValue, query.ExchangeCode)]
+	if !ok || cache.expired(entry, time.Now()) {
+		return nil, false
+	}
+
+	return &MappingResponse{
+		Data:    entry.Data,
+		Warning: entry.Warning,
+	}, true
+}
+
+// cacheable returns true if `resp` matched a listing or OpenFIGI reported
+// that no identifier was found. Errors, which may be caused by rate limits
+// or server problems, are not cached.
+func cacheable(resp *MappingResponse) bool {
+	if resp.Error != "" {
+		return false
+	}
+	return len(resp.Data) > 0 || strings.HasPrefix(resp.Warning, noMatchWarning)
+}
+
+// Put caches the response OpenFIGI returned for `query`. Responses that
+// are neither a match nor a "No identifier found" warning are ignored.
+func (cache *Cache) Put(query *OpenFigiQuery, resp *MappingResponse) {
+	if !cacheable(resp) {
+		return
+	}
+	cache.entries[cacheKey(query.IdType, query.IdValue, query.ExchangeCode)] = &CacheEntry{
+		IdType:       query.IdType,
+		IdValue:      query.IdValue,
+		ExchangeCode: query.ExchangeCode,
+		Data:         resp.Data,
+		Warning:      resp.Warning,
+		Fetched:      time.Now().Unix(),
+	}
+	cache.dirty = true
+}
+
+// Entries returns the cached entries sorted by id type and value
+func (cache *Cache) Entries() []*CacheEntry {
+	entries := make([]*CacheEntry, 0, len(cache.entries))
+	for _, entry := range cache.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return cacheKey(entries[i].IdType, entries[i].IdValue, entries[i].ExchangeCode) <
+			cacheKey(entries[j].IdType, entries[j].IdValue, entries[j].ExchangeCode)
+	})
+	return entries
+}
+
+// Expired returns true if `entry` has outlived its TTL
+func (cache *Cache) Expired(entry *CacheEntry) bool {
+	return cache.expired(entry, time.Now())
+}
+
+// Stats counts the positive, negative and expired entries of the cache
+func (cache *Cache) Stats() *CacheStats {
+	now := time.Now()
+	stats := &CacheStats{Entries: len(cache.entries)}
+	for _, entry := range cache.entries {
+		if entry.negative() {
+			stats.Negative++
+		} else {
+			stats.Positive++
+		}
+		if cache.expired(entry, now) {
+			stats.Expired++
+		}
+	}
+	return stats
+}
+
+// Prune removes expired entries, and all negative entries if `negative` is
+// true, and returns the number of entries removed
+func (cache *Cache) Prune(negative bool) int {
+	now := time.Now()
+	removed := 0
+	for key, entry := range cache.entries {
+		if cache.expired(entry, now) || (negative && entry.negative()) {
+			delete(cache.entries, key)
+			removed++
+		}
+	}
+	if removed > 0 {
+		cache.dirty = true
+	}
+	return removed
+}
+
+// Save writes the cache to disk if it changed since it was loaded
+func (cache *Cache) Save() error {
+	if !cache.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(cache.Entries())
+	if err != nil {
+		log.Error().Err(err).Msg("could not marshal openfigi cache")
+		return err
+	}
+
+	if dir := filepath.Dir(cache.fn); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Error().Err(err).Str("Dir", dir).Msg("could not create openfigi cache directory")
+			return err
+		}
+	}
+
+	tmp := fmt.Sprintf("%s.tmp", cache.fn)
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Error().Err(err).Str("FileName", tmp).Msg("could not write openfigi cache")
+		return err
+	}
+	if err := os.Rename(tmp, cache.fn); err != nil {
+		log.Error().Err(err).Str("FileName", cache.fn).Msg("could not write openfigi cache")
+		return err
+	}
+
+	cache.dirty = false
+	log.Info().Str("FileName", cache.fn).Int("NumEntries", len(cache.entries)).Msg("saved openfigi cache")
+	return nil
+}
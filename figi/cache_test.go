@@ -0,0 +1,113 @@
+package figi
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	matchQuery   = &OpenFigiQuery{IdType: "TICKER", IdValue: "AAPL", ExchangeCode: "US"}
+	noMatchQuery = &OpenFigiQuery{IdType: "TICKER", IdValue: "NOPE", ExchangeCode: "US"}
+	errorQuery   = &OpenFigiQuery{IdType: "TICKER", IdValue: "LIMIT", ExchangeCode: "US"}
+)
+
+func fillCache(cache *Cache) {
+	cache.Put(matchQuery, &MappingResponse{Data: []*OpenFigiAsset{{CompositeFIGI: "BBG000B9XRY4", Ticker: "AAPL"}}})
+	cache.Put(noMatchQuery, &MappingResponse{Warning: "No identifier found."})
+	cache.Put(errorQuery, &MappingResponse{Error: "Too Many Requests"})
+}
+
+func TestCachePut(t *testing.T) {
+	cache := OpenCache(filepath.Join(t.TempDir(), "cache.json"), time.Hour, time.Hour)
+	fillCache(cache)
+
+	if resp, ok := cache.Get(matchQuery); !ok || len(resp.Data) != 1 || resp.Data[0].CompositeFIGI != "BBG000B9XRY4" {
+		t.Errorf("Get(AAPL) = %+v, %t, want the cached match", resp, ok)
+	}
+	if resp, ok := cache.Get(noMatchQuery); !ok || len(resp.Data) != 0 || resp.Warning == "" {
+		t.Errorf("Get(NOPE) = %+v, %t, want the cached no-match warning", resp, ok)
+	}
+	if _, ok := cache.Get(errorQuery); ok {
+		t.Error("error responses should not be cached")
+	}
+	if _, ok := cache.Get(&OpenFigiQuery{IdType: "TICKER", IdValue: "AAPL", ExchangeCode: "UN"}); ok {
+		t.Error("entries are keyed by exchange code")
+	}
+
+	stats := cache.Stats()
+	if stats.Entries != 2 || stats.Positive != 1 || stats.Negative != 1 || stats.Expired != 0 {
+		t.Errorf("stats = %+v, want 1 positive and 1 negative entry", stats)
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	cache := OpenCache(filepath.Join(t.TempDir(), "cache.json"), time.Hour, time.Minute)
+	fillCache(cache)
+
+	// age the no-match entry past the negative ttl but not the positive ttl
+	for _, entry := range cache.Entries() {
+		entry.Fetched = time.Now().Add(-10 * time.Minute).Unix()
+	}
+
+	if _, ok := cache.Get(matchQuery); !ok {
+		t.Error("match expired before its ttl")
+	}
+	if _, ok := cache.Get(noMatchQuery); ok {
+		t.Error("no-match entry outlived the negative ttl")
+	}
+	if stats := cache.Stats(); stats.Expired != 1 {
+		t.Errorf("Expired = %d, want 1", stats.Expired)
+	}
+
+	if removed := cache.Prune(false); removed != 1 {
+		t.Errorf("Prune(false) removed %d entries, want 1", removed)
+	}
+	if removed := cache.Prune(true); removed != 0 {
+		t.Errorf("Prune(true) removed %d entries, want 0", removed)
+	}
+	if len(cache.Entries()) != 1 {
+		t.Errorf("%d entries left, want 1", len(cache.Entries()))
+	}
+}
+
+func TestCacheSave(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "nested", "cache.json")
+	cache := OpenCache(fn, time.Hour, time.Hour)
+	fillCache(cache)
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	reopened := OpenCache(fn, time.Hour, time.Hour)
+	if len(reopened.Entries()) != 2 {
+		t.Fatalf("reopened cache has %d entries, want 2", len(reopened.Entries()))
+	}
+	if _, ok := reopened.Get(matchQuery); !ok {
+		t.Error("match missing after reopening the cache")
+	}
+	if _, ok := reopened.Get(noMatchQuery); !ok {
+		t.Error("no-match entry missing after reopening the cache")
+	}
+}
+
+func TestLoadCache(t *testing.T) {
+	defer viper.Reset()
+
+	viper.Set("openfigi.cache_file", "")
+	if cache := LoadCache(); cache != nil {
+		t.Error("LoadCache should return nil when openfigi.cache_file is empty")
+	}
+
+	viper.Set("openfigi.cache_file", filepath.Join(t.TempDir(), "cache.json"))
+	viper.Set("openfigi.cache_negative_ttl", "1h")
+	cache := LoadCache()
+	if cache == nil {
+		t.Fatal("LoadCache returned nil")
+	}
+	if cache.PositiveTTL != DefaultCachePositiveTTL || cache.NegativeTTL != time.Hour {
+		t.Errorf("ttls = %s, %s, want %s, 1h", cache.PositiveTTL, cache.NegativeTTL, DefaultCachePositiveTTL)
+	}
+}
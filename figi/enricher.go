@@ -0,0 +1,24 @@
+package figi
+
+import (
+	"context"
+
+	"github.com/penny-vault/import-tickers/common"
+)
+
+func init() {
+	common.RegisterEnricher(&Enricher{})
+}
+
+// Enricher looks up composite and share class figi's for assets that are
+// missing them. At most max assets are queried; 0 means no limit.
+type Enricher struct{}
+
+func (e *Enricher) Name() string {
+	return "figi"
+}
+
+func (e *Enricher) Enrich(ctx context.Context, assets []*common.Asset, max int) []*common.Asset {
+	Enrich(ctx, assets, max)
+	return assets
+}
@@ -2,22 +2,32 @@ package figi
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/penny-vault/import-tickers/common"
+	"github.com/penny-vault/import-tickers/validate"
 	"github.com/rs/zerolog/log"
-	"github.com/schollz/progressbar/v3"
 	"github.com/spf13/viper"
 	"golang.org/x/time/rate"
 )
 
 const (
-	OPENFIGI_MAPPING_URL string = "https://api.openfigi.com/v3/mapping"
+	OPENFIGI_BASE_URL string = "https://api.openfigi.com/v3"
 )
 
+var httpClient *http.Client
+
+// MappingResponse is the result of a single mapping query. Queries that
+// match nothing have a warning instead of data; invalid queries have an
+// error.
 type MappingResponse struct {
-	Data []*OpenFigiAsset `json:"data"`
+	Data    []*OpenFigiAsset `json:"data"`
+	Warning string           `json:"warning"`
+	Error   string           `json:"error"`
 }
 
 type OpenFigiAsset struct {
@@ -40,25 +50,51 @@ type OpenFigiQuery struct {
 	MarketSectorDescription string `json:"marketSecDes"`
 }
 
-func rateLimit() *rate.Limiter {
+// SetHTTPClient sets the http client used for all requests made to openfigi.
+// Passing nil restores the default client.
+func SetHTTPClient(client *http.Client) {
+	httpClient = client
+}
+
+func newClient() *resty.Client {
+	client := resty.New()
+	if httpClient != nil {
+		client = resty.NewWithClient(httpClient)
+	}
+	return common.NewRetryPolicy("openfigi").Apply(client)
+}
+
+// baseUrl returns the openfigi API root, which may be overridden with the
+// openfigi.base_url config setting
+func baseUrl() string {
+	if url := viper.GetString("openfigi.base_url"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return OPENFIGI_BASE_URL
+}
+
+// RateLimit returns a limiter for the mapping endpoint, which allows 25
+// requests every 6 seconds with an API key
+func RateLimit() *rate.Limiter {
 	dur := (time.Second * 6) / 25
 	openFigiRate := rate.Every(dur)
 	return rate.NewLimiter(openFigiRate, 10)
 }
 
-func mapFigis(query []*OpenFigiQuery) ([]*MappingResponse, error) {
+func mapFigis(ctx context.Context, query []*OpenFigiQuery) ([]*MappingResponse, error) {
 	if len(query) > 100 {
 		log.Error().Msg("programming error - too many assets in request")
 	}
 
 	apiKey := viper.GetString("openfigi.apikey")
 	mappingResponse := make([]*MappingResponse, 0)
-	client := resty.New()
+	client := newClient()
 	resp, err := client.R().
+		SetContext(ctx).
 		SetHeader("X-OPENFIGI-APIKEY", apiKey).
 		SetBody(query).
 		SetResult(&mappingResponse).
-		Post(OPENFIGI_MAPPING_URL)
+		Post(baseUrl() + "/mapping")
 
 	if err != nil {
 		log.Error().Err(err).Msg("OpenFigi api called errored out")
@@ -67,113 +103,94 @@ func mapFigis(query []*OpenFigiQuery) ([]*MappingResponse, error) {
 
 	if resp.StatusCode() >= 400 {
 		log.Error().Int("StatusCode", resp.StatusCode()).Str("Body", string(resp.Body())).Msg("openfigi api call returned invalid status code")
-		return []*MappingResponse{}, err
+		return []*MappingResponse{}, fmt.Errorf("openfigi returned status code %d", resp.StatusCode())
 	}
 
 	return mappingResponse, nil
 }
 
-func Enrich(assets []*common.Asset) {
-	rateLimiter := rateLimit()
+// NeedsLookup returns true if `asset` is listed and its composite figi is
+// missing or malformed or its asset type is unknown
+func NeedsLookup(asset *common.Asset) bool {
+	invalidFigi := validate.FIGI(asset.CompositeFigi) != nil
+	return (invalidFigi || asset.AssetType == common.UnknownAsset) && asset.DelistingDate == ""
+}
+
+// Enrich looks up the figi's of at most `max` assets that need them; 0 means
+// no limit
+func Enrich(ctx context.Context, assets []*common.Asset, max int) {
+	rateLimiter := RateLimit()
 
 	emptyFigis := make([]*common.Asset, 0, 100)
 	for _, asset := range assets {
-		if asset.CompositeFigi == "UNKNOWN" {
+		if asset.CompositeFigi == common.UnknownFigi {
 			asset.CompositeFigi = ""
 		}
 
-		if (asset.CompositeFigi == "" || asset.AssetType == common.UnknownAsset) && asset.DelistingDate == "" {
+		if NeedsLookup(asset) {
 			emptyFigis = append(emptyFigis, asset)
 		}
 	}
 
-	figiMap := LookupFigi(emptyFigis, rateLimiter)
-	for _, asset := range emptyFigis {
-		if assetFigi, ok := figiMap[asset.Ticker]; ok {
+	if max > 0 && len(emptyFigis) > max {
+		emptyFigis = emptyFigis[:max]
+	}
+
+	result, err := LookupFigi(ctx, emptyFigis, rateLimiter)
+	if err != nil {
+		log.Error().Err(err).Msg("invalid openfigi configuration - skipping figi lookup")
+		return
+	}
+	result.LogUnmatched()
+
+	for _, match := range result.Matches {
+		asset := match.Asset
+		assetFigi := match.Figi
+		if asset.CompositeFigi != assetFigi.CompositeFIGI {
+			asset.RecordChange("CompositeFigi", asset.CompositeFigi, assetFigi.CompositeFIGI, match.Source())
 			asset.CompositeFigi = assetFigi.CompositeFIGI
+		}
+		if asset.ShareClassFigi != assetFigi.ShareClassFIGI {
+			asset.RecordChange("ShareClassFigi", asset.ShareClassFigi, assetFigi.ShareClassFIGI, match.Source())
 			asset.ShareClassFigi = assetFigi.ShareClassFIGI
+		}
 
-			if asset.AssetType == common.UnknownAsset {
-				switch assetFigi.SecurityType2 {
-				case "Partnership Shares":
-					asset.AssetType = common.CommonStock
-				case "Depositary Receipt":
-					asset.AssetType = common.ADRC
-				case "Common Stock":
-					asset.AssetType = common.CommonStock
-				case "Mutual Fund":
-					switch assetFigi.SecurityType {
-					case "ETP":
-						asset.AssetType = common.ETF
-					case "Open-End Fund":
-						asset.AssetType = common.MutualFund
-					case "Closed-End Fund":
-						asset.AssetType = common.CEF
-					default:
-						log.Warn().
-							Str("SecurityType", assetFigi.SecurityType).
-							Str("SecurityType2", assetFigi.SecurityType2).
-							Str("Ticker", asset.Ticker).
-							Str("CompositeFigi", assetFigi.CompositeFIGI).
-							Msg("asset type is unknown and openfigi security type 2 is unknown")
-					}
+		if asset.AssetType == common.UnknownAsset {
+			switch assetFigi.SecurityType2 {
+			case "Partnership Shares":
+				asset.AssetType = common.CommonStock
+			case "Depositary Receipt":
+				asset.AssetType = common.ADRC
+			case "Common Stock":
+				asset.AssetType = common.CommonStock
+			case "Mutual Fund":
+				switch assetFigi.SecurityType {
+				case "ETP":
+					asset.AssetType = common.ETF
+				case "Open-End Fund":
 					asset.AssetType = common.MutualFund
-				case "":
+				case "Closed-End Fund":
+					asset.AssetType = common.CEF
 				default:
 					log.Warn().
 						Str("SecurityType", assetFigi.SecurityType).
 						Str("SecurityType2", assetFigi.SecurityType2).
 						Str("Ticker", asset.Ticker).
 						Str("CompositeFigi", assetFigi.CompositeFIGI).
-						Msg("asset type is unknown and openfigi security type is unknown")
-				}
-			}
-
-			asset.LastUpdated = time.Now().Unix()
-		}
-	}
-}
-
-func LookupFigi(assets []*common.Asset, rateLimiter *rate.Limiter) map[string]*OpenFigiAsset {
-	query := make([]*OpenFigiQuery, 0, 100)
-	result := make(map[string]*OpenFigiAsset)
-	var bar *progressbar.ProgressBar
-	if !viper.GetBool("display.hide_progress") {
-		bar = progressbar.Default(int64(len(assets)))
-	}
-
-	for _, asset := range assets {
-		if !viper.GetBool("display.hide_progress") {
-			bar.Add(1)
-		}
-		query = append(query, &OpenFigiQuery{
-			IdType:                  "TICKER",
-			IdValue:                 asset.Ticker,
-			ExchangeCode:            "US",
-			MarketSectorDescription: "Equity",
-		})
-
-		if len(query) == 100 {
-			rateLimiter.Wait(context.Background())
-			mappingResponse, _ := mapFigis(query)
-			for _, resp := range mappingResponse {
-				for _, figiAsset := range resp.Data {
-					result[figiAsset.Ticker] = figiAsset
+						Msg("asset type is unknown and openfigi security type 2 is unknown")
 				}
+				asset.AssetType = common.MutualFund
+			case "":
+			default:
+				log.Warn().
+					Str("SecurityType", assetFigi.SecurityType).
+					Str("SecurityType2", assetFigi.SecurityType2).
+					Str("Ticker", asset.Ticker).
+					Str("CompositeFigi", assetFigi.CompositeFIGI).
+					Msg("asset type is unknown and openfigi security type is unknown")
 			}
-			query = make([]*OpenFigiQuery, 0, 100)
 		}
-	}
 
-	if len(query) > 0 {
-		rateLimiter.Wait(context.Background())
-		mappingResponse, _ := mapFigis(query)
-		for _, resp := range mappingResponse {
-			for _, figiAsset := range resp.Data {
-				result[figiAsset.Ticker] = figiAsset
-			}
-		}
+		asset.LastUpdated = time.Now().Unix()
 	}
-
-	return result
 }
@@ -0,0 +1,282 @@
+package figi
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/penny-vault/import-tickers/common"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+)
+
+// DefaultIdTypes is the order identifiers are tried in when
+// openfigi.id_types is not set
+var DefaultIdTypes = []string{"TICKER", "ID_CUSIP", "ID_ISIN", "ID_BB_GLOBAL_SHARE_CLASS_LEVEL"}
+
+// idValues maps each supported OpenFIGI identifier type to the asset field
+// holding its value. CIK is not an OpenFIGI identifier type so assets can't
+// be mapped by it.
+var idValues = map[string]func(asset *common.Asset) string{
+	"TICKER":                         func(asset *common.Asset) string { return asset.Ticker },
+	"ID_CUSIP":                       func(asset *common.Asset) string { return asset.CUSIP },
+	"ID_ISIN":                        func(asset *common.Asset) string { return asset.ISIN },
+	"ID_BB_GLOBAL_SHARE_CLASS_LEVEL": func(asset *common.Asset) string { return asset.ShareClassFigi },
+}
+
+// Match is the OpenFIGI listing found for an asset along with the identifier
+// that produced it. Candidates is the number of listings OpenFIGI returned;
+// Ambiguous is true when the preferences didn't single one out.
+type Match struct {
+	Asset      *common.Asset
+	Figi       *OpenFigiAsset
+	IdType     string
+	IdValue    string
+	Candidates int
+	Ambiguous  bool
+}
+
+// Unmatched is an asset that no identifier could be mapped for. Reason is
+// the warning or error OpenFIGI returned for the last identifier tried.
+type Unmatched struct {
+	Asset  *common.Asset
+	Reason string
+}
+
+// LookupResult holds the outcome of looking up each asset
+type LookupResult struct {
+	Matches   []*Match
+	Unmatched []*Unmatched
+}
+
+// Source is the change source recorded for fields set from the match
+func (match *Match) Source() string {
+	return fmt.Sprintf("openfigi:%s", match.IdType)
+}
+
+// IdTypes returns the identifier types to look assets up by, in order, from
+// the openfigi.id_types config setting
+func IdTypes() ([]string, error) {
+	if !viper.IsSet("openfigi.id_types") {
+		return DefaultIdTypes, nil
+	}
+
+	idTypes := make([]string, 0)
+	for _, idType := range viper.GetStringSlice("openfigi.id_types") {
+		idType = strings.ToUpper(strings.TrimSpace(idType))
+		if _, ok := idValues[idType]; !ok {
+			supported := make([]string, 0, len(idValues))
+			for name := range idValues {
+				supported = append(supported, name)
+			}
+			return nil, fmt.Errorf("unsupported openfigi id type '%s' (supported: %s)", idType, strings.Join(supported, ", "))
+		}
+		idTypes = append(idTypes, idType)
+	}
+
+	if len(idTypes) == 0 {
+		return nil, fmt.Errorf("openfigi.id_types is empty")
+	}
+
+	return idTypes, nil
+}
+
+// LookupFigi maps `assets` to OpenFIGI listings. Each identifier type in
+// openfigi.id_types is tried in order for assets that have a value for it
+// and haven't been matched yet. When a query returns several listings the
+// one preferred by openfigi.exchange_codes and openfigi.security_types is
+// used. Requests are sent in batches of 100 queries using openfigi.workers
+// workers; responses are cached in openfigi.cache_file, which is not
+// written when dry_run is set.
+func LookupFigi(ctx context.Context, assets []*common.Asset, rateLimiter *rate.Limiter) (*LookupResult, error) {
+	idTypes, err := IdTypes()
+	if err != nil {
+		return nil, err
+	}
+	prefs := LoadPreferences()
+	cache := LoadCache()
+
+	result := &LookupResult{
+		Matches:   make([]*Match, 0, len(assets)),
+		Unmatched: make([]*Unmatched, 0),
+	}
+	reasons := make(map[*common.Asset]string, len(assets))
+
+	pending := assets
+	for _, idType := range idTypes {
+		if len(pending) == 0 || ctx.Err() != nil {
+			break
+		}
+
+		queryAssets := make([]*common.Asset, 0, len(pending))
+		queries := make([]*OpenFigiQuery, 0, len(pending))
+		unmatched := make([]*common.Asset, 0, len(pending))
+		for _, asset := range pending {
+			value := idValues[idType](asset)
+			if value == "" {
+				unmatched = append(unmatched, asset)
+				continue
+			}
+			queryAssets = append(queryAssets, asset)
+			queries = append(queries, &OpenFigiQuery{
+				IdType:                  idType,
+				IdValue:                 value,
+				ExchangeCode:            "US",
+				MarketSectorDescription: "Equity",
+			})
+		}
+
+		responses := runQueries(ctx, queries, rateLimiter, cache)
+		numMatched := 0
+		for ii, asset := range queryAssets {
+			query := queries[ii]
+			resp := responses[ii]
+			subLog := log.With().Str("Ticker", asset.Ticker).Str("IdType", idType).Str("IdValue", query.IdValue).Logger()
+
+			switch {
+			case resp == nil:
+				reasons[asset] = "request failed"
+			case resp.Error != "":
+				subLog.Warn().Str("Error", resp.Error).Msg("openfigi rejected query")
+				reasons[asset] = resp.Error
+			case len(resp.Data) == 0:
+				reasons[asset] = resp.Warning
+				if reasons[asset] == "" {
+					reasons[asset] = "no match"
+				}
+			}
+
+			if resp == nil || len(resp.Data) == 0 {
+				unmatched = append(unmatched, asset)
+				continue
+			}
+
+			best, ambiguous := prefs.Choose(resp.Data)
+			if ambiguous {
+				subLog.Warn().Int("Candidates", len(resp.Data)).Str("CompositeFigi", best.CompositeFIGI).Str("ExchangeCode", best.ExchangeCode).Str("SecurityType", best.SecurityType).Msg("openfigi returned several equally preferred listings")
+			} else if len(resp.Data) > 1 {
+				subLog.Debug().Int("Candidates", len(resp.Data)).Str("CompositeFigi", best.CompositeFIGI).Msg("chose preferred openfigi listing")
+			}
+
+			result.Matches = append(result.Matches, &Match{
+				Asset:      asset,
+				Figi:       best,
+				IdType:     idType,
+				IdValue:    query.IdValue,
+				Candidates: len(resp.Data),
+				Ambiguous:  ambiguous,
+			})
+			numMatched++
+		}
+
+		log.Info().Str("IdType", idType).Int("Queried", len(queries)).Int("Matched", numMatched).Int("Remaining", len(unmatched)).Msg("openfigi lookup")
+		pending = unmatched
+	}
+
+	if cache != nil && !viper.GetBool("dry_run") {
+		cache.Save()
+	}
+
+	for _, asset := range pending {
+		reason, ok := reasons[asset]
+		if !ok {
+			reason = "no identifier to query"
+		}
+		result.Unmatched = append(result.Unmatched, &Unmatched{Asset: asset, Reason: reason})
+	}
+
+	return result, nil
+}
+
+// LogUnmatched logs each asset that couldn't be mapped and the number of
+// assets per reason
+func (result *LookupResult) LogUnmatched() {
+	counts := make(map[string]int)
+	for _, unmatched := range result.Unmatched {
+		counts[unmatched.Reason]++
+		log.Info().Str("Ticker", unmatched.Asset.Ticker).Str("Reason", unmatched.Reason).Msg("no openfigi match")
+	}
+
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		log.Info().Str("Reason", reason).Int("NumAssets", counts[reason]).Msg("unmatched openfigi assets")
+	}
+}
+
+// runQueries sends `queries` to the mapping endpoint in batches of 100. The
+// returned responses are in the same order as `queries`; the response of a
+// query in a failed batch is nil. Queries with an unexpired entry in `cache`
+// aren't sent and the responses received are added to it.
+func runQueries(ctx context.Context, queries []*OpenFigiQuery, rateLimiter *rate.Limiter, cache *Cache) []*MappingResponse {
+	responses := make([]*MappingResponse, len(queries))
+
+	uncached := queries
+	positions := make([]int, len(queries))
+	for ii := range queries {
+		positions[ii] = ii
+	}
+
+	if cache != nil {
+		uncached = make([]*OpenFigiQuery, 0, len(queries))
+		positions = positions[:0]
+		for ii, query := range queries {
+			if resp, ok := cache.Get(query); ok {
+				responses[ii] = resp
+				continue
+			}
+			uncached = append(uncached, query)
+			positions = append(positions, ii)
+		}
+		log.Debug().Int("NumQueries", len(queries)).Int("NumCached", len(queries)-len(uncached)).Msg("openfigi cache lookup")
+	}
+
+	fetched := fetchQueries(ctx, uncached, rateLimiter)
+	for ii, resp := range fetched {
+		responses[positions[ii]] = resp
+		if cache != nil && resp != nil {
+			cache.Put(uncached[ii], resp)
+		}
+	}
+
+	return responses
+}
+
+// fetchQueries sends `queries` to the mapping endpoint in batches of 100
+// using openfigi.workers workers
+func fetchQueries(ctx context.Context, queries []*OpenFigiQuery, rateLimiter *rate.Limiter) []*MappingResponse {
+	responses := make([]*MappingResponse, len(queries))
+
+	batches := make([]int, 0, len(queries)/100+1)
+	for start := 0; start < len(queries); start += 100 {
+		batches = append(batches, start)
+	}
+
+	pool := common.NewWorkerPool("openfigi", rateLimiter)
+	common.RunPool(ctx, pool, batches, func(ctx context.Context, start int) error {
+		end := start + 100
+		if end > len(queries) {
+			end = len(queries)
+		}
+
+		batch, err := mapFigis(ctx, queries[start:end])
+		if err != nil {
+			return err
+		}
+		if len(batch) != end-start {
+			log.Error().Int("NumQueries", end-start).Int("NumResponses", len(batch)).Msg("openfigi returned a different number of responses than queries")
+			return fmt.Errorf("openfigi returned %d responses for %d queries", len(batch), end-start)
+		}
+
+		// batches write to disjoint ranges of responses
+		copy(responses[start:end], batch)
+		return nil
+	})
+
+	return responses
+}
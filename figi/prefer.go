@@ -0,0 +1,90 @@
+package figi
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// DefaultExchangeCodes is the exchange preference used when
+// openfigi.exchange_codes is not set
+var DefaultExchangeCodes = []string{"US"}
+
+// DefaultSecurityTypes is the security type preference used when
+// openfigi.security_types is not set
+var DefaultSecurityTypes = []string{"Common Stock", "ETP", "Open-End Fund", "Closed-End Fund", "ADR", "REIT", "MLP"}
+
+// Preferences rank the listings OpenFIGI returns for a single query. A
+// listing whose exchange code or security type appears earlier in the list
+// is preferred; listings not in a list rank last.
+type Preferences struct {
+	ExchangeCodes []string
+	SecurityTypes []string
+}
+
+// LoadPreferences reads openfigi.exchange_codes and openfigi.security_types
+func LoadPreferences() *Preferences {
+	prefs := &Preferences{
+		ExchangeCodes: DefaultExchangeCodes,
+		SecurityTypes: DefaultSecurityTypes,
+	}
+	if viper.IsSet("openfigi.exchange_codes") {
+		prefs.ExchangeCodes = viper.GetStringSlice("openfigi.exchange_codes")
+	}
+	if viper.IsSet("openfigi.security_types") {
+		prefs.SecurityTypes = viper.GetStringSlice("openfigi.security_types")
+	}
+	return prefs
+}
+
+// Choose returns the most preferred of `candidates` and whether it is tied
+// with a listing of a different security. Ties are broken by composite figi
+// so the choice doesn't depend on the order of OpenFIGI's response.
+func (prefs *Preferences) Choose(candidates []*OpenFigiAsset) (*OpenFigiAsset, bool) {
+	if len(candidates) == 0 {
+		return nil, false
+	}
+
+	ranked := make([]*OpenFigiAsset, len(candidates))
+	copy(ranked, candidates)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		ri, rj := prefs.rank(ranked[i]), prefs.rank(ranked[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return ranked[i].CompositeFIGI < ranked[j].CompositeFIGI
+	})
+
+	best := ranked[0]
+	ambiguous := false
+	for _, other := range ranked[1:] {
+		if prefs.rank(other) == prefs.rank(best) && other.CompositeFIGI != best.CompositeFIGI {
+			ambiguous = true
+			break
+		}
+	}
+
+	return best, ambiguous
+}
+
+// rank orders listings by exchange preference first, then security type
+func (prefs *Preferences) rank(listing *OpenFigiAsset) int {
+	exchange := indexOf(prefs.ExchangeCodes, listing.ExchangeCode)
+	securityType := indexOf(prefs.SecurityTypes, listing.SecurityType)
+	if alt := indexOf(prefs.SecurityTypes, listing.SecurityType2); alt < securityType {
+		securityType = alt
+	}
+	return exchange*(len(prefs.SecurityTypes)+1) + securityType
+}
+
+// indexOf returns the position of `value` in `list` ignoring case, or the
+// length of the list if it isn't found
+func indexOf(list []string, value string) int {
+	for ii, item := range list {
+		if strings.EqualFold(item, value) {
+			return ii
+		}
+	}
+	return len(list)
+}
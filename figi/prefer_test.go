@@ -0,0 +1,98 @@
+package figi
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestChoose(t *testing.T) {
+	prefs := &Preferences{
+		ExchangeCodes: []string{"US", "UN"},
+		SecurityTypes: []string{"Common Stock", "ETP"},
+	}
+
+	tests := []struct {
+		name       string
+		candidates []*OpenFigiAsset
+		want       string
+		ambiguous  bool
+	}{
+		{"none", nil, "", false},
+		{
+			"preferred exchange",
+			[]*OpenFigiAsset{
+				{CompositeFIGI: "BBG000B9XRY4", ExchangeCode: "UN", SecurityType: "Common Stock"},
+				{CompositeFIGI: "BBG000BPH459", ExchangeCode: "US", SecurityType: "Common Stock"},
+			},
+			"BBG000BPH459", false,
+		},
+		{
+			"preferred security type",
+			[]*OpenFigiAsset{
+				{CompositeFIGI: "BBG000B9XRY4", ExchangeCode: "US", SecurityType: "ETP"},
+				{CompositeFIGI: "BBG000BPH459", ExchangeCode: "US", SecurityType: "Common Stock"},
+			},
+			"BBG000BPH459", false,
+		},
+		{
+			"security type 2",
+			[]*OpenFigiAsset{
+				{CompositeFIGI: "BBG000B9XRY4", ExchangeCode: "US", SecurityType: "Open-End Fund", SecurityType2: "Mutual Fund"},
+				{CompositeFIGI: "BBG000BPH459", ExchangeCode: "US", SecurityType: "Unit", SecurityType2: "Common Stock"},
+			},
+			"BBG000BPH459", false,
+		},
+		{
+			"unlisted exchange ranks last",
+			[]*OpenFigiAsset{
+				{CompositeFIGI: "BBG000B9XRY4", ExchangeCode: "LN", SecurityType: "Common Stock"},
+				{CompositeFIGI: "BBG000BPH459", ExchangeCode: "us", SecurityType: "REIT"},
+			},
+			"BBG000BPH459", false,
+		},
+		{
+			"tie broken by composite figi",
+			[]*OpenFigiAsset{
+				{CompositeFIGI: "BBG000BPH459", ExchangeCode: "US", SecurityType: "Common Stock"},
+				{CompositeFIGI: "BBG000B9XRY4", ExchangeCode: "US", SecurityType: "Common Stock"},
+			},
+			"BBG000B9XRY4", true,
+		},
+		{
+			"same security isn't ambiguous",
+			[]*OpenFigiAsset{
+				{Figi: "BBG000B9XVV8", CompositeFIGI: "BBG000B9XRY4", ExchangeCode: "US", SecurityType: "Common Stock"},
+				{Figi: "BBG000B9Y9N4", CompositeFIGI: "BBG000B9XRY4", ExchangeCode: "US", SecurityType: "Common Stock"},
+			},
+			"BBG000B9XRY4", false,
+		},
+	}
+
+	for _, tt := range tests {
+		best, ambiguous := prefs.Choose(tt.candidates)
+		got := ""
+		if best != nil {
+			got = best.CompositeFIGI
+		}
+		if got != tt.want || ambiguous != tt.ambiguous {
+			t.Errorf("%s: Choose() = %q, %t, want %q, %t", tt.name, got, ambiguous, tt.want, tt.ambiguous)
+		}
+	}
+}
+
+func TestLoadPreferences(t *testing.T) {
+	defer viper.Reset()
+
+	prefs := LoadPreferences()
+	if len(prefs.ExchangeCodes) != len(DefaultExchangeCodes) || len(prefs.SecurityTypes) != len(DefaultSecurityTypes) {
+		t.Errorf("preferences = %+v, want the defaults", prefs)
+	}
+
+	viper.Set("openfigi.exchange_codes", []string{"UN", "UW"})
+	viper.Set("openfigi.security_types", []string{"ETP"})
+	prefs = LoadPreferences()
+	if len(prefs.ExchangeCodes) != 2 || prefs.ExchangeCodes[0] != "UN" || len(prefs.SecurityTypes) != 1 || prefs.SecurityTypes[0] != "ETP" {
+		t.Errorf("preferences = %+v, want the configured lists", prefs)
+	}
+}
@@ -0,0 +1,202 @@
+package figi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/penny-vault/import-tickers/common"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+)
+
+// DefaultDiscoverSecurityTypes are the securityType2 values discovered when
+// openfigi.discover_security_types is not set. "Mutual Fund" covers ETPs,
+// open-end and closed-end funds.
+var DefaultDiscoverSecurityTypes = []string{"Common Stock", "Depositary Receipt", "Mutual Fund", "Partnership Shares"}
+
+// FilterQuery selects the listings returned by the OpenFIGI filter and
+// search endpoints. Start is the `next` token of the previous page and is
+// managed by Filter and Search.
+type FilterQuery struct {
+	Query                   string `json:"query,omitempty"`
+	Start                   string `json:"start,omitempty"`
+	ExchangeCode            string `json:"exchCode,omitempty"`
+	MarketSectorDescription string `json:"marketSecDes,omitempty"`
+	SecurityType            string `json:"securityType,omitempty"`
+	SecurityType2           string `json:"securityType2,omitempty"`
+}
+
+// FilterResponse is a single page of filter or search results. Next is
+// empty on the last page; Total is only returned by the filter endpoint.
+type FilterResponse struct {
+	Data  []*OpenFigiAsset `json:"data"`
+	Next  string           `json:"next"`
+	Total int              `json:"total"`
+	Error string           `json:"error"`
+}
+
+// searchRateLimit returns a limiter for the filter and search endpoints,
+// which allow 20 requests a minute with an API key and 5 without
+func searchRateLimit() *rate.Limiter {
+	perMinute := 5
+	if apiKey := viper.GetString("openfigi.apikey"); apiKey != "" && apiKey != "<not-set>" {
+		perMinute = 20
+	}
+	return rate.NewLimiter(rate.Every(time.Minute/time.Duration(perMinute)), 1)
+}
+
+// DiscoverSecurityTypes returns the securityType2 values to discover from
+// the openfigi.discover_security_types config setting
+func DiscoverSecurityTypes() []string {
+	if viper.IsSet("openfigi.discover_security_types") {
+		return viper.GetStringSlice("openfigi.discover_security_types")
+	}
+	return DefaultDiscoverSecurityTypes
+}
+
+// Filter returns every listing matching `query` from the OpenFIGI filter
+// endpoint, following the `next` token until the last page or until
+// `maxPages` pages have been read; 0 means no limit. The listings read
+// before an error or cancellation are returned along with the error.
+func Filter(ctx context.Context, query *FilterQuery, maxPages int) ([]*OpenFigiAsset, error) {
+	return paginate(ctx, "/filter", query, maxPages)
+}
+
+// Search is like Filter but uses the OpenFIGI search endpoint, which
+// requires query.Query to be set
+func Search(ctx context.Context, query *FilterQuery, maxPages int) ([]*OpenFigiAsset, error) {
+	if query.Query == "" {
+		return nil, errors.New("openfigi search requires a query")
+	}
+	return paginate(ctx, "/search", query, maxPages)
+}
+
+func paginate(ctx context.Context, endpoint string, query *FilterQuery, maxPages int) ([]*OpenFigiAsset, error) {
+	limiter := searchRateLimit()
+	page := *query
+	page.Start = ""
+
+	listings := make([]*OpenFigiAsset, 0)
+	for numPages := 0; ; numPages++ {
+		if maxPages > 0 && numPages >= maxPages {
+			log.Warn().Str("Endpoint", endpoint).Int("MaxPages", maxPages).Int("NumListings", len(listings)).Msg("stopped reading openfigi results after max pages")
+			break
+		}
+
+		if err := limiter.Wait(ctx); err != nil {
+			if ctx.Err() != nil {
+				return listings, ctx.Err()
+			}
+			return listings, err
+		}
+
+		resp, err := fetchFilterPage(endpoint, &page)
+		if err != nil {
+			return listings, err
+		}
+
+		listings = append(listings, resp.Data...)
+		log.Debug().Str("Endpoint", endpoint).Int("Page", numPages+1).Int("NumListings", len(listings)).Int("Total", resp.Total).Msg("read openfigi results page")
+
+		if resp.Next == "" {
+			break
+		}
+		page.Start = resp.Next
+	}
+
+	return listings, nil
+}
+
+func fetchFilterPage(endpoint string, query *FilterQuery) (*FilterResponse, error) {
+	apiKey := viper.GetString("openfigi.apikey")
+	filterResponse := &FilterResponse{}
+	client := newClient()
+	resp, err := client.R().
+		SetHeader("X-OPENFIGI-APIKEY", apiKey).
+		SetBody(query).
+		SetResult(filterResponse).
+		Post(baseUrl() + endpoint)
+
+	if err != nil {
+		log.Error().Err(err).Str("Endpoint", endpoint).Msg("OpenFigi api called errored out")
+		return nil, err
+	}
+
+	if resp.StatusCode() >= 400 {
+		log.Error().Int("StatusCode", resp.StatusCode()).Str("Endpoint", endpoint).Str("Body", string(resp.Body())).Msg("openfigi api call returned invalid status code")
+		return nil, fmt.Errorf("openfigi returned status code %d", resp.StatusCode())
+	}
+
+	if filterResponse.Error != "" {
+		log.Error().Str("Endpoint", endpoint).Str("Error", filterResponse.Error).Msg("openfigi rejected query")
+		return nil, fmt.Errorf("openfigi rejected query: %s", filterResponse.Error)
+	}
+
+	return filterResponse, nil
+}
+
+// Discover lists the US composite equity listings of each securityType2 in
+// `securityTypes`. Listings are de-duplicated by composite figi and sorted
+// by ticker. `maxPages` limits the pages read per security type.
+func Discover(ctx context.Context, securityTypes []string, maxPages int) ([]*OpenFigiAsset, error) {
+	seen := make(map[string]bool)
+	discovered := make([]*OpenFigiAsset, 0)
+
+	var err error
+	for _, securityType := range securityTypes {
+		var listings []*OpenFigiAsset
+		listings, err = Filter(ctx, &FilterQuery{
+			ExchangeCode:            "US",
+			MarketSectorDescription: "Equity",
+			SecurityType2:           securityType,
+		}, maxPages)
+
+		numNew := 0
+		for _, listing := range listings {
+			if listing.CompositeFIGI == "" || seen[listing.CompositeFIGI] {
+				continue
+			}
+			seen[listing.CompositeFIGI] = true
+			discovered = append(discovered, listing)
+			numNew++
+		}
+		log.Info().Str("SecurityType2", securityType).Int("NumListings", len(listings)).Int("NumNew", numNew).Msg("discovered openfigi listings")
+
+		if err != nil {
+			break
+		}
+	}
+
+	sort.SliceStable(discovered, func(i, j int) bool {
+		return discovered[i].Ticker < discovered[j].Ticker
+	})
+
+	return discovered, err
+}
+
+// MissingFromUniverse returns the `listings` whose composite figi and
+// ticker don't match any asset in `universe`
+func MissingFromUniverse(listings []*OpenFigiAsset, universe []*common.Asset) []*OpenFigiAsset {
+	figis := make(map[string]bool, len(universe))
+	tickers := make(map[string]bool, len(universe))
+	for _, asset := range universe {
+		if asset.CompositeFigi != "" {
+			figis[asset.CompositeFigi] = true
+		}
+		tickers[asset.Ticker] = true
+	}
+
+	missing := make([]*OpenFigiAsset, 0)
+	for _, listing := range listings {
+		if figis[listing.CompositeFIGI] || tickers[listing.Ticker] {
+			continue
+		}
+		missing = append(missing, listing)
+	}
+
+	return missing
+}
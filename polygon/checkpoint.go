@@ -0,0 +1,95 @@
+/*
+Copyright 2022
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package polygon
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/penny-vault/import-tickers/common"
+	"github.com/rs/zerolog/log"
+)
+
+// pageCheckpoint is the progress of an asset download saved after each page
+type pageCheckpoint struct {
+	Created int64           `json:"created"`
+	Page    int             `json:"page"`
+	NextUrl string          `json:"next_url"`
+	Assets  []*common.Asset `json:"assets"`
+}
+
+// loadCheckpoint reads the checkpoint saved in `fn`. nil is returned if
+// there is no checkpoint or it is older than `maxAge` (0 means any age).
+func loadCheckpoint(fn string, maxAge time.Duration) *pageCheckpoint {
+	if fn == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warn().Err(err).Str("FileName", fn).Msg("could not read checkpoint")
+		}
+		return nil
+	}
+
+	checkpoint := &pageCheckpoint{}
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		log.Warn().Err(err).Str("FileName", fn).Msg("could not parse checkpoint - starting from the first page")
+		return nil
+	}
+
+	if maxAge > 0 && time.Since(time.Unix(checkpoint.Created, 0)) > maxAge {
+		log.Info().Str("FileName", fn).Time("Created", time.Unix(checkpoint.Created, 0)).Msg("checkpoint is too old - starting from the first page")
+		return nil
+	}
+
+	if checkpoint.NextUrl == "" {
+		return nil
+	}
+
+	return checkpoint
+}
+
+func (checkpoint *pageCheckpoint) save(fn string) {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		log.Warn().Err(err).Msg("could not marshal checkpoint")
+		return
+	}
+
+	// write to a temporary file first so an interrupted write doesn't
+	// corrupt the previous checkpoint
+	tmp := fn + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Warn().Err(err).Str("FileName", tmp).Msg("could not save checkpoint")
+		return
+	}
+	if err := os.Rename(tmp, fn); err != nil {
+		log.Warn().Err(err).Str("FileName", fn).Msg("could not save checkpoint")
+	}
+}
+
+func removeCheckpoint(fn string) {
+	if fn == "" {
+		return
+	}
+	if err := os.Remove(fn); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warn().Err(err).Str("FileName", fn).Msg("could not remove checkpoint")
+	}
+}
@@ -0,0 +1,39 @@
+/*
+Copyright 2022
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package polygon
+
+import (
+	"context"
+
+	"github.com/penny-vault/import-tickers/common"
+)
+
+func init() {
+	common.RegisterEnricher(&DetailEnricher{})
+}
+
+// DetailEnricher fills listing date, description, corporate url, icon and logo urls
+// from the polygon ticker detail endpoint
+type DetailEnricher struct{}
+
+func (e *DetailEnricher) Name() string {
+	return "polygon"
+}
+
+func (e *DetailEnricher) Enrich(ctx context.Context, assets []*common.Asset, max int) []*common.Asset {
+	EnrichDetail(ctx, assets, max)
+	return assets
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2022
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package polygon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/penny-vault/import-tickers/common"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+)
+
+type PolygonTickerEventsResponse struct {
+	Results   *PolygonTickerEvents `json:"results"`
+	Status    string               `json:"status"`
+	RequestId string               `json:"request_id"`
+}
+
+type PolygonTickerEvents struct {
+	Name          string                `json:"name"`
+	CompositeFigi string                `json:"composite_figi"`
+	CIK           string                `json:"cik"`
+	Events        []*PolygonTickerEvent `json:"events"`
+}
+
+type PolygonTickerEvent struct {
+	Type         string               `json:"type"`
+	Date         string               `json:"date"`
+	TickerChange *PolygonTickerChange `json:"ticker_change"`
+}
+
+type PolygonTickerChange struct {
+	Ticker string `json:"ticker"`
+}
+
+// tickerEventsJob holds the ticker changes found for a single asset
+type tickerEventsJob struct {
+	asset   *common.Asset
+	changes []*common.TickerChange
+}
+
+// FetchTickerChanges retrieves the ticker change history of each asset from
+// polygon using polygon.workers workers. At most `max` assets are looked up;
+// 0 means no limit. Mutual funds and FRED series have no polygon events and
+// are skipped. Lookups stop when `ctx` is cancelled.
+func FetchTickerChanges(ctx context.Context, assets []*common.Asset, max int) []*common.TickerChange {
+	jobs := make([]*tickerEventsJob, 0, len(assets))
+	for _, asset := range assets {
+		if asset.AssetType == common.MutualFund || asset.AssetType == common.FRED {
+			continue
+		}
+		if max > 0 && len(jobs) >= max {
+			log.Info().Int("Max", max).Int("NumSkipped", len(assets)-len(jobs)).Msg("ticker event lookups limited by max")
+			break
+		}
+		jobs = append(jobs, &tickerEventsJob{asset: asset})
+	}
+
+	pool := common.NewWorkerPool("polygon", rateLimit())
+	common.RunPool(ctx, pool, jobs, func(ctx context.Context, job *tickerEventsJob) error {
+		var err error
+		job.changes, err = fetchTickerEvents(ctx, job.asset.Ticker)
+		return err
+	})
+
+	changes := make([]*common.TickerChange, 0)
+	for _, job := range jobs {
+		changes = append(changes, job.changes...)
+	}
+
+	if ctx.Err() != nil {
+		log.Warn().Int("NumChanges", len(changes)).Msg("ticker event download interrupted")
+	}
+
+	return changes
+}
+
+// FetchTickerEvents retrieves ticker change events for the security
+// identified by `id` (a ticker, CUSIP, or composite figi) and converts them
+// to a list of ticker changes ordered by date
+func FetchTickerEvents(ctx context.Context, id string, limit *rate.Limiter) ([]*common.TickerChange, error) {
+	if err := limit.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return fetchTickerEvents(ctx, id)
+}
+
+func fetchTickerEvents(ctx context.Context, id string) ([]*common.TickerChange, error) {
+	client := newClient()
+
+	id = strings.ReplaceAll(id, "/", ".")
+	urlClean := fmt.Sprintf("%s/vX/reference/tickers/%s/events?types=ticker_change&apiKey=", baseUrl(), id)
+	url := fmt.Sprintf("%s%s", urlClean, viper.GetString("polygon.token"))
+	subLog := log.With().Str("Url", urlClean).Str("Source", "polygon.io").Logger()
+
+	resp, err := client.R().SetContext(ctx).Get(url)
+	if err != nil {
+		subLog.Error().Err(err).Msg("error when fetching ticker events")
+		return nil, err
+	}
+
+	if resp.StatusCode() >= 400 {
+		subLog.Error().Int("StatusCode", resp.StatusCode()).Msg("error code received from server when fetching ticker events")
+		return nil, fmt.Errorf("polygon returned status code %d", resp.StatusCode())
+	}
+
+	eventsResponse := PolygonTickerEventsResponse{}
+	if err := json.Unmarshal(resp.Body(), &eventsResponse); err != nil {
+		subLog.Error().Stack().Err(err).Msg("could not unmarshal response body when fetching ticker events")
+		return nil, err
+	}
+
+	if eventsResponse.Status != "OK" || eventsResponse.Results == nil {
+		subLog.Error().Str("PolygonStatus", eventsResponse.Status).Msg("polygon status code not OK")
+		return nil, fmt.Errorf("polygon status not OK")
+	}
+
+	return tickerChangesFromEvents(eventsResponse.Results), nil
+}
+
+// tickerChangesFromEvents converts polygon's list of "ticker became X on
+// date" events into old -> new ticker changes. The first event is the
+// original listing and has no previous ticker so it is not a change.
+func tickerChangesFromEvents(results *PolygonTickerEvents) []*common.TickerChange {
+	events := make([]*PolygonTickerEvent, 0, len(results.Events))
+	for _, event := range results.Events {
+		if event.Type != "ticker_change" || event.TickerChange == nil {
+			continue
+		}
+		// undated events can't be ordered and can't be saved
+		if event.Date == "" {
+			log.Warn().Str("CompositeFigi", results.CompositeFigi).Str("Ticker", event.TickerChange.Ticker).Msg("skipping ticker event without a date")
+			continue
+		}
+		events = append(events, event)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Date < events[j].Date
+	})
+
+	changes := make([]*common.TickerChange, 0, len(events))
+	for ii := 1; ii < len(events); ii++ {
+		oldTicker := strings.ReplaceAll(events[ii-1].TickerChange.Ticker, ".", "/")
+		newTicker := strings.ReplaceAll(events[ii].TickerChange.Ticker, ".", "/")
+		if oldTicker == newTicker {
+			continue
+		}
+		changes = append(changes, &common.TickerChange{
+			CompositeFigi: results.CompositeFigi,
+			OldTicker:     oldTicker,
+			NewTicker:     newTicker,
+			Date:          events[ii].Date,
+			Source:        "api.polygon.io",
+		})
+	}
+
+	return changes
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2022
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package polygon
+
+import (
+	"context"
+
+	"github.com/penny-vault/import-tickers/common"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	common.RegisterEnricher(&IconEnricher{})
+}
+
+// IconEnricher downloads the icon and logo images of assets whose image url
+// changed and saves them by content address to icons.dir
+type IconEnricher struct{}
+
+func (e *IconEnricher) Name() string {
+	return "icons"
+}
+
+func (e *IconEnricher) Enrich(ctx context.Context, assets []*common.Asset, max int) []*common.Asset {
+	FetchIcons(ctx, assets, max)
+	common.SaveIcons(assets, viper.GetString("icons.dir"))
+	return assets
+}
+
+// NeedsIcons returns true if the asset has an icon or logo url that has not
+// been downloaded
+func NeedsIcons(asset *common.Asset) bool {
+	return (asset.IconUrl != "" && asset.IconHash == "") || (asset.LogoUrl != "" && asset.LogoHash == "")
+}
+
+// FetchIcons downloads the icon and logo images of at most `max` assets that
+// need them (see NeedsIcons) into Asset.Icon and Asset.Logo; 0 means no limit
+func FetchIcons(ctx context.Context, assets []*common.Asset, max int) {
+	pending := make([]*common.Asset, 0)
+	for _, asset := range assets {
+		if NeedsIcons(asset) {
+			pending = append(pending, asset)
+		}
+	}
+
+	if max > 0 && len(pending) > max {
+		pending = pending[:max]
+	}
+
+	log.Info().Int("NumAssets", len(pending)).Msg("downloading icons")
+
+	// each asset may need two requests so the rate limit is applied per
+	// request rather than by the pool
+	limit := rateLimit()
+	pool := common.NewWorkerPool("polygon", nil)
+	common.RunPool(ctx, pool, pending, func(ctx context.Context, asset *common.Asset) error {
+		var err error
+		if asset.IconUrl != "" && asset.IconHash == "" {
+			if asset.Icon, err = fetchIcon(ctx, asset.IconUrl, limit); err != nil {
+				return err
+			}
+		}
+		if asset.LogoUrl != "" && asset.LogoHash == "" {
+			if asset.Logo, err = fetchIcon(ctx, asset.LogoUrl, limit); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2022
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package polygon
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/penny-vault/import-tickers/common"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// DefaultInactiveLookback is how far back inactive tickers are read when
+// polygon.inactive_lookback is not set
+const DefaultInactiveLookback = 30 * 24 * time.Hour
+
+// EnrichDelisted sets the actual delisting date of `assets` along with the
+// final name, exchange, and identifiers reported by polygon. Polygon's
+// inactive tickers are read once, newest delisting first, back to
+// polygon.inactive_lookback before now. It returns the number of assets
+// updated; assets polygon doesn't report as delisted are left untouched.
+func EnrichDelisted(ctx context.Context, assets []*common.Asset) int {
+	pending := make([]*common.Asset, 0, len(assets))
+	for _, asset := range assets {
+		if asset.AssetType != common.MutualFund && asset.AssetType != common.FRED {
+			pending = append(pending, asset)
+		}
+	}
+	if len(pending) == 0 {
+		return 0
+	}
+
+	lookback := DefaultInactiveLookback
+	if viper.IsSet("polygon.inactive_lookback") {
+		lookback = viper.GetDuration("polygon.inactive_lookback")
+	}
+	since := time.Now().Add(-lookback).UTC().Format("2006-01-02")
+
+	inactive, err := FetchInactive(ctx, since, viper.GetInt("polygon.max_pages"))
+	if err != nil && len(inactive) == 0 {
+		return 0
+	}
+
+	byTicker := make(map[string][]*PolygonAsset, len(inactive))
+	for _, record := range inactive {
+		ticker := strings.ReplaceAll(record.Ticker, ".", "/")
+		byTicker[ticker] = append(byTicker[ticker], record)
+	}
+
+	updated := 0
+	for _, asset := range pending {
+		match := matchInactive(asset, byTicker[asset.Ticker])
+		if match == nil {
+			log.Debug().Str("Ticker", asset.Ticker).Msg("no matching inactive ticker found")
+			continue
+		}
+		applyInactive(asset, match)
+		updated++
+	}
+
+	log.Info().Int("NumAssets", len(pending)).Int("NumInactive", len(inactive)).Int("NumUpdated", updated).Str("Since", since).Msg("matched delisted assets with polygon inactive tickers")
+	return updated
+}
+
+// FetchInactive returns the securities polygon reports as delisted on or
+// after `since` (YYYY-MM-DD). Pages are read newest delisting first until a
+// page reaches `since` or `maxPages` pages have been read; 0 means no limit.
+// The records read before an error are returned along with it.
+func FetchInactive(ctx context.Context, since string, maxPages int) ([]*PolygonAsset, error) {
+	limit := rateLimit()
+	subLog := log.With().Str("Source", "polygon.io").Logger()
+	pageUrl := fmt.Sprintf("%s/v3/reference/tickers?market=stocks&active=false&sort=delisted_utc&order=desc&limit=1000", baseUrl())
+
+	inactive := make([]*PolygonAsset, 0)
+	for pageNum := 1; ; pageNum++ {
+		if maxPages > 0 && pageNum > maxPages {
+			subLog.Warn().Int("MaxPages", maxPages).Int("NumInactive", len(inactive)).Msg("page limit reached before all inactive tickers since the lookback were read")
+			return inactive, nil
+		}
+		if err := limit.Wait(ctx); err != nil {
+			subLog.Warn().Err(err).Int("Page", pageNum).Msg("inactive ticker download interrupted")
+			return inactive, err
+		}
+
+		resp, err := fetchAssetPage(ctx, pageUrl)
+		if err != nil {
+			return inactive, err
+		}
+
+		reachedSince := false
+		for _, record := range resp.Results {
+			delistingDate := polygonDate(record.DelistedUTC)
+			if delistingDate != "" && delistingDate < since {
+				reachedSince = true
+				break
+			}
+			inactive = append(inactive, record)
+		}
+
+		if reachedSince || resp.NextUrl == "" {
+			return inactive, nil
+		}
+		pageUrl = resp.NextUrl
+	}
+}
+
+// matchInactive selects the inactive record describing `asset`. Tickers are
+// re-used after a security is delisted so records with a different composite
+// figi, without a delisting date, or delisted before the asset was listed are
+// ignored; of the remaining records the most recently delisted is used.
+func matchInactive(asset *common.Asset, inactive []*PolygonAsset) *PolygonAsset {
+	var match *PolygonAsset
+	matchDate := ""
+	for _, record := range inactive {
+		if asset.CompositeFigi != "" && record.CompositeFigi != "" && asset.CompositeFigi != record.CompositeFigi {
+			continue
+		}
+
+		delistingDate := polygonDate(record.DelistedUTC)
+		if delistingDate == "" {
+			continue
+		}
+
+		if asset.ListingDate != "" && delistingDate < asset.ListingDate {
+			continue
+		}
+
+		if delistingDate > matchDate {
+			match = record
+			matchDate = delistingDate
+		}
+	}
+	return match
+}
+
+func applyInactive(asset *common.Asset, record *PolygonAsset) {
+	const source = "api.polygon.io"
+
+	delistingDate := polygonDate(record.DelistedUTC)
+	asset.RecordChange("DelistingDate", asset.DelistingDate, delistingDate, source)
+	asset.DelistingDate = delistingDate
+
+	if record.Name != "" {
+		setField(asset, "Name", &asset.Name, record.Name)
+	}
+	if record.PrimaryExchange != "" {
+		setField(asset, "PrimaryExchange", &asset.PrimaryExchange, record.PrimaryExchange)
+	}
+	if record.CompositeFigi != "" {
+		setField(asset, "CompositeFigi", &asset.CompositeFigi, record.CompositeFigi)
+	}
+	if record.ShareClassFigi != "" {
+		setField(asset, "ShareClassFigi", &asset.ShareClassFigi, record.ShareClassFigi)
+	}
+	if record.CIK != "" {
+		setField(asset, "CIK", &asset.CIK, record.CIK)
+	}
+
+	log.Info().Str("Ticker", asset.Ticker).Str("DelistingDate", delistingDate).Msg("found delisting date")
+}
+
+// polygonDate converts a polygon UTC timestamp to a YYYY-MM-DD date; an
+// empty string is returned if the timestamp can't be parsed
+func polygonDate(timestamp string) string {
+	if timestamp == "" {
+		return ""
+	}
+	tm, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		log.Warn().Err(err).Str("Timestamp", timestamp).Msg("could not parse polygon timestamp")
+		return ""
+	}
+	return tm.UTC().Format("2006-01-02")
+}
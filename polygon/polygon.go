@@ -20,17 +20,26 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/penny-vault/import-tickers/common"
 	"github.com/rs/zerolog/log"
-	"github.com/schollz/progressbar/v3"
 	"github.com/spf13/viper"
 	"golang.org/x/time/rate"
 )
 
+const (
+	DefaultBaseUrl = "https://api.polygon.io"
+)
+
+// ErrPageLimit is returned when the universe has more pages than polygon.max_pages
+var ErrPageLimit = errors.New("polygon page limit reached")
+
+var httpClient *http.Client
+
 type PolygonAssetsResponse struct {
 	Results   []*PolygonAsset `json:"results"`
 	Status    string          `json:"status"`
@@ -45,6 +54,7 @@ type PolygonAsset struct {
 	CompositeFigi   string `json:"composite_figi"`
 	CurrencyName    string `json:"currency_name"`
 	LastUpdatedUTC  string `json:"last_updated_utc"`
+	DelistedUTC     string `json:"delisted_utc"`
 	Locale          string `json:"locale"`
 	Market          string `json:"market"`
 	Name            string `json:"name"`
@@ -92,11 +102,34 @@ type PolygonAssetDetail struct {
 	SicDescription              string          `json:"sic_description"`
 	TickerRoot                  string          `json:"ticker_root"`
 	HomepageUrl                 string          `json:"homepage_url"`
-	TotalEmployees              int             `json:"total_employees"`
+	TotalEmployees              int64           `json:"total_employees"`
 	ListingDate                 string          `json:"list_date"`
 	Branding                    PolygonBranding `json:"branding"`
-	ShareClassSharesOutstanding int             `json:"share_class_shares_outstanding"`
-	WeightedSharesOutstanding   int             `json:"weighted_shares_outstanding"`
+	ShareClassSharesOutstanding int64           `json:"share_class_shares_outstanding"`
+	WeightedSharesOutstanding   int64           `json:"weighted_shares_outstanding"`
+}
+
+// SetHTTPClient sets the http client used for all requests made to polygon.
+// Passing nil restores the default client.
+func SetHTTPClient(client *http.Client) {
+	httpClient = client
+}
+
+func newClient() *resty.Client {
+	client := resty.New()
+	if httpClient != nil {
+		client = resty.NewWithClient(httpClient)
+	}
+	return common.NewRetryPolicy("polygon").Apply(client)
+}
+
+// baseUrl returns the polygon API host, which may be overridden with the
+// polygon.base_url config setting
+func baseUrl() string {
+	if url := viper.GetString("polygon.base_url"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return DefaultBaseUrl
 }
 
 func rateLimit() *rate.Limiter {
@@ -105,49 +138,56 @@ func rateLimit() *rate.Limiter {
 	return rate.NewLimiter(polygonRate, 2)
 }
 
-func EnrichDetail(assets []*common.Asset, max int) {
+// EnrichDetail fetches ticker details for at most `max` assets (0 means no
+// limit) whose details are older than polygon.detail_age. Lookups run
+// concurrently using polygon.workers workers.
+func EnrichDetail(ctx context.Context, assets []*common.Asset, max int) {
 	maxPolygonDetailAge := viper.GetInt64("polygon.detail_age")
-	polygonRateLimiter := rateLimit()
-
-	var bar *progressbar.ProgressBar
-	if !viper.GetBool("display.hide_progress") {
-		bar = progressbar.Default(int64(len(assets)))
-	}
-
 	now := time.Now().Unix()
-	count := 0
-	for _, asset := range assets {
-		if !viper.GetBool("display.hide_progress") {
-			bar.Add(1)
-		}
 
-		count++
+	pending := make([]*common.Asset, 0)
+	for _, asset := range assets {
 		if asset.AssetType != common.MutualFund && asset.AssetType != common.FRED && (asset.PolygonDetailAge+maxPolygonDetailAge) < now {
-			FetchAssetDetail(asset, polygonRateLimiter)
-			asset.PolygonDetailAge = now
-		}
-		if max > 0 && max < count {
-			break
+			pending = append(pending, asset)
 		}
 	}
 
+	if max > 0 && len(pending) > max {
+		pending = pending[:max]
+	}
+
+	log.Info().Int("NumAssets", len(pending)).Msg("fetching polygon details")
+
+	pool := common.NewWorkerPool("polygon", rateLimit())
+	common.RunPool(ctx, pool, pending, func(ctx context.Context, asset *common.Asset) error {
+		if err := fetchAssetDetail(ctx, asset); err != nil {
+			return err
+		}
+		asset.PolygonDetailAge = now
+		return nil
+	})
 }
 
+// FetchAssetDetail updates `asset` with the polygon ticker details
 func FetchAssetDetail(asset *common.Asset, limit *rate.Limiter) *common.Asset {
 	limit.Wait(context.Background())
+	fetchAssetDetail(context.Background(), asset)
+	return asset
+}
 
-	client := resty.New()
+func fetchAssetDetail(ctx context.Context, asset *common.Asset) error {
+	client := newClient()
 
 	ticker := strings.ReplaceAll(asset.Ticker, "/", ".")
-	urlClean := fmt.Sprintf("https://api.polygon.io/v3/reference/tickers/%s?apiKey=", ticker)
+	urlClean := fmt.Sprintf("%s/v3/reference/tickers/%s?apiKey=", baseUrl(), ticker)
 	url := fmt.Sprintf("%s%s", urlClean, viper.GetString("polygon.token"))
 	subLog := log.With().Str("Url", urlClean).Str("Source", "polygon.io").Logger()
 
-	resp, err := client.R().Get(url)
+	resp, err := client.R().SetContext(ctx).Get(url)
 
 	if err != nil {
 		subLog.Error().Err(err).Msg("error when fetching list of assets")
-		return asset
+		return err
 	}
 
 	if resp.StatusCode() >= 400 {
@@ -155,131 +195,195 @@ func FetchAssetDetail(asset *common.Asset, limit *rate.Limiter) *common.Asset {
 	}
 
 	body := resp.Body()
-	if err != nil {
-		subLog.Error().Stack().Err(err).Msg("could not read response body when fetching assets")
-		return asset
-	}
 
 	assetDetail := PolygonAssetDetailResponse{}
 	if err := json.Unmarshal(body, &assetDetail); err != nil {
 		subLog.Error().Stack().Err(err).Msg("could not unmarshal response body when fetching assets")
-		return asset
+		return err
 	}
 
-	if assetDetail.Status != "OK" {
-		subLog.Error().Str("PolygonStatus", assetDetail.Status).Err(err).Msg("polygon status code not OK")
-		return asset
+	if assetDetail.Status != "OK" || assetDetail.Result == nil {
+		subLog.Error().Str("PolygonStatus", assetDetail.Status).Msg("polygon status code not OK")
+		return fmt.Errorf("polygon status not OK")
 	}
 
-	asset.ListingDate = assetDetail.Result.ListingDate
-	asset.CorporateUrl = assetDetail.Result.HomepageUrl
-	asset.Description = assetDetail.Result.Description
+	setField(asset, "ListingDate", &asset.ListingDate, assetDetail.Result.ListingDate)
+	setField(asset, "CorporateUrl", &asset.CorporateUrl, assetDetail.Result.HomepageUrl)
+	setField(asset, "Description", &asset.Description, assetDetail.Result.Description)
+	setField(asset, "SicCode", &asset.SicCode, assetDetail.Result.SicCode)
+	setField(asset, "SicDescription", &asset.SicDescription, assetDetail.Result.SicDescription)
+	setField(asset, "PhoneNumber", &asset.PhoneNumber, assetDetail.Result.PhoneNumber)
+	if address := assetDetail.Result.Address; address != nil {
+		setField(asset, "Address", &asset.Address, address.Address1)
+
+		loc := common.NewLocation(address.City, address.State, address.PostalCode, assetDetail.Result.Locale)
+		setField(asset, "HeadquartersCity", &asset.HeadquartersCity, loc.City)
+		setField(asset, "HeadquartersState", &asset.HeadquartersState, loc.State)
+		setField(asset, "HeadquartersPostalCode", &asset.HeadquartersPostalCode, loc.PostalCode)
+		setField(asset, "HeadquartersCountry", &asset.HeadquartersCountry, loc.Country)
+		setField(asset, "HeadquartersLocation", &asset.HeadquartersLocation, loc.String())
+	}
+
+	setFloat64Field(asset, "MarketCap", &asset.MarketCap, assetDetail.Result.MarketCap)
+	setInt64Field(asset, "TotalEmployees", &asset.TotalEmployees, assetDetail.Result.TotalEmployees)
+	setInt64Field(asset, "ShareClassSharesOutstanding", &asset.ShareClassSharesOutstanding, assetDetail.Result.ShareClassSharesOutstanding)
+	setInt64Field(asset, "WeightedSharesOutstanding", &asset.WeightedSharesOutstanding, assetDetail.Result.WeightedSharesOutstanding)
+
+	// images are downloaded by the icons stage
+	asset.SetIconUrl(assetDetail.Result.Branding.IconUrl, "api.polygon.io")
+	asset.SetLogoUrl(assetDetail.Result.Branding.LogoUrl, "api.polygon.io")
 
-	// fetch icon
-	if assetDetail.Result.Branding.IconUrl != "" {
-		asset.IconUrl = assetDetail.Result.Branding.IconUrl
+	return nil
+}
+
+// setField updates `dest` to `value` and records the change on the asset
+func setField(asset *common.Asset, field string, dest *string, value string) {
+	if *dest == value {
+		return
 	}
+	asset.RecordChange(field, *dest, value, "api.polygon.io")
+	*dest = value
+}
 
-	return asset
+// setInt64Field is setField for integer fields
+func setInt64Field(asset *common.Asset, field string, dest *int64, value int64) {
+	if *dest == value {
+		return
+	}
+	asset.RecordChange(field, common.FormatInt64(*dest), common.FormatInt64(value), "api.polygon.io")
+	*dest = value
 }
 
+// setFloat64Field is setField for decimal fields
+func setFloat64Field(asset *common.Asset, field string, dest *float64, value float64) {
+	if *dest == value {
+		return
+	}
+	asset.RecordChange(field, common.FormatFloat64(*dest), common.FormatFloat64(value), "api.polygon.io")
+	*dest = value
+}
+
+// FetchIcon downloads the image at `url`. An empty slice is returned if the
+// download fails.
 func FetchIcon(url string, limit *rate.Limiter) []byte {
-	limit.Wait(context.Background())
+	data, _ := fetchIcon(context.Background(), url, limit)
+	return data
+}
+
+func fetchIcon(ctx context.Context, url string, limit *rate.Limiter) ([]byte, error) {
+	if err := limit.Wait(ctx); err != nil {
+		return []byte{}, err
+	}
 	subLog := log.With().Str("Url", url).Str("Source", "polygon.io").Logger()
 	url = fmt.Sprintf("%s?apiKey=%s", url, viper.GetString("polygon.token"))
 
-	client := resty.New()
-	resp, err := client.R().Get(url)
+	client := newClient()
+	resp, err := client.R().SetContext(ctx).Get(url)
 	if err != nil {
 		subLog.Error().Err(err).Msg("error when fetching icon")
-		return []byte{}
+		return []byte{}, err
 	}
 
 	if resp.StatusCode() >= 400 {
 		subLog.Error().Int("StatusCode", resp.StatusCode()).Msg("error code received from server when fetching icon")
-		return []byte{}
+		return []byte{}, fmt.Errorf("polygon returned status code %d", resp.StatusCode())
 	}
 
-	body := resp.Body()
-	if err != nil {
-		subLog.Error().Stack().Err(err).Msg("could not read response body when fetching icon")
-		return []byte{}
-	}
-
-	return body
+	return resp.Body(), nil
 }
 
-func FetchAssets(maxPages int) ([]*common.Asset, error) {
+// FetchAssets downloads the list of active assets from polygon. Pages are
+// requested until polygon stops returning a next url. If `maxPages` is
+// greater than 0 and more pages are available ErrPageLimit is returned. When
+// polygon.checkpoint_file is set progress is saved after each page so an
+// interrupted download resumes where it left off.
+func FetchAssets(ctx context.Context, maxPages int) ([]*common.Asset, error) {
 	limit := rateLimit()
 	assets := []*common.Asset{}
-	url := "https://api.polygon.io/v3/reference/tickers?market=stocks&active=true&sort=ticker&order=asc&limit=1000"
+	url := fmt.Sprintf("%s/v3/reference/tickers?market=stocks&active=true&sort=ticker&order=asc&limit=1000", baseUrl())
 	subLog := log.With().Str("Source", "polygon.io").Logger()
 	pageNum := 1
+
+	checkpointFn := viper.GetString("polygon.checkpoint_file")
+	if checkpoint := loadCheckpoint(checkpointFn, viper.GetDuration("polygon.checkpoint_max_age")); checkpoint != nil {
+		subLog.Info().Str("FileName", checkpointFn).Int("Page", checkpoint.Page).Int("NumAssets", len(checkpoint.Assets)).Msg("resuming download from checkpoint")
+		assets = checkpoint.Assets
+		url = checkpoint.NextUrl
+		pageNum = checkpoint.Page
+	}
+
 	for {
-		if pageNum > maxPages {
-			break
+		if maxPages > 0 && pageNum > maxPages {
+			subLog.Error().Int("MaxPages", maxPages).Int("NumAssets", len(assets)).Str("NextUrl", url).Msg("page limit reached before all assets were downloaded")
+			return assets, fmt.Errorf("%w: more than %d pages", ErrPageLimit, maxPages)
+		}
+		if err := limit.Wait(ctx); err != nil {
+			subLog.Warn().Err(err).Int("Page", pageNum).Msg("asset download interrupted")
+			return assets, err
 		}
-		limit.Wait(context.Background())
 		subLog.Info().Str("Url", url).Int("Page", pageNum).Msg("Loading page")
-		pageNum++
-		resp, err := fetchAssetPage(url)
+		resp, err := fetchAssetPage(ctx, url)
 		if err != nil {
 			return []*common.Asset{}, err
 		}
-		if resp.Status == "OK" {
-			for _, asset := range resp.Results {
-				ticker := strings.ReplaceAll(asset.Ticker, ".", "/")
-				newAsset := &common.Asset{
-					Ticker:          ticker,
-					Name:            asset.Name,
-					PrimaryExchange: asset.PrimaryExchange,
-					CompositeFigi:   asset.CompositeFigi,
-					ShareClassFigi:  asset.ShareClassFigi,
-					CIK:             asset.CIK,
-					Source:          "api.polygon.io",
-				}
-				switch asset.Type {
-				case "CS":
-					newAsset.AssetType = common.CommonStock
-				case "ETF":
-					newAsset.AssetType = common.ETF
-				case "ETN":
-					newAsset.AssetType = common.ETN
-				case "FUND":
-					newAsset.AssetType = common.CEF
-				case "ADRC":
-					newAsset.AssetType = common.ADRC
-				case "":
-					newAsset.AssetType = common.UnknownAsset
-				default:
-					// this is an asset type we aren't following - discard
-					continue
-				}
-				assets = append(assets, newAsset)
-			}
-			if resp.NextUrl == "" {
-				break
-			}
-			url = resp.NextUrl
-		} else {
+		if resp.Status != "OK" {
 			log.Error().Str("Status", resp.Status).Str("RequestId", resp.RequestId).Int("Count", resp.Count).Str("NextUrl", resp.NextUrl).Msg("breaking due to error in polygon response")
 			return assets, errors.New("invalid response received")
 		}
+
+		for _, asset := range resp.Results {
+			ticker := strings.ReplaceAll(asset.Ticker, ".", "/")
+			newAsset := &common.Asset{
+				Ticker:          ticker,
+				Name:            asset.Name,
+				PrimaryExchange: asset.PrimaryExchange,
+				CompositeFigi:   asset.CompositeFigi,
+				ShareClassFigi:  asset.ShareClassFigi,
+				CIK:             asset.CIK,
+				Source:          "api.polygon.io",
+			}
+			assetType, ok := assetTypeFromPolygon(asset.Type)
+			if !ok {
+				// this is an asset type we aren't following - discard
+				continue
+			}
+			newAsset.AssetType = assetType
+			assets = append(assets, newAsset)
+		}
+
+		if resp.NextUrl == "" {
+			break
+		}
+		url = resp.NextUrl
+		pageNum++
+
+		if checkpointFn != "" {
+			checkpoint := &pageCheckpoint{
+				Created: time.Now().Unix(),
+				Page:    pageNum,
+				NextUrl: url,
+				Assets:  assets,
+			}
+			checkpoint.save(checkpointFn)
+		}
 	}
+
+	removeCheckpoint(checkpointFn)
 	return assets, nil
 }
 
-func fetchAssetPage(url string) (PolygonAssetsResponse, error) {
+func fetchAssetPage(ctx context.Context, url string) (PolygonAssetsResponse, error) {
 	// add url to log BEFORE the apikey is added in order not to expose a secret
 	subLog := log.With().Str("Url", url).Str("Source", "polygon.io").Logger()
 	// add apiKey
 	url = fmt.Sprintf("%s&apiKey=%s", url, viper.GetString("polygon.token"))
 
 	assetsResponse := PolygonAssetsResponse{}
-	client := resty.New()
+	client := newClient()
 
 	resp, err := client.
 		R().
+		SetContext(ctx).
 		Get(url)
 
 	if err != nil {
@@ -309,3 +413,24 @@ func fetchAssetPage(url string) (PolygonAssetsResponse, error) {
 
 	return assetsResponse, nil
 }
+
+// assetTypeFromPolygon maps a polygon ticker type to an asset type. The
+// second return value is false for types that are not imported.
+func assetTypeFromPolygon(polygonType string) (common.AssetType, bool) {
+	switch polygonType {
+	case "CS":
+		return common.CommonStock, true
+	case "ETF":
+		return common.ETF, true
+	case "ETN":
+		return common.ETN, true
+	case "FUND":
+		return common.CEF, true
+	case "ADRC":
+		return common.ADRC, true
+	case "":
+		return common.UnknownAsset, true
+	default:
+		return "", false
+	}
+}
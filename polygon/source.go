@@ -0,0 +1,42 @@
+/*
+Copyright 2022
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package polygon
+
+import (
+	"context"
+
+	"github.com/penny-vault/import-tickers/common"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	common.RegisterSource(&Source{})
+}
+
+// Source provides stocks, ETFs, and closed-end funds from polygon.io
+type Source struct{}
+
+func (s *Source) Name() string {
+	return "polygon"
+}
+
+func (s *Source) FetchAssets(ctx context.Context) ([]*common.Asset, error) {
+	return FetchAssets(ctx, viper.GetInt("polygon.max_pages"))
+}
+
+func (s *Source) MinAssets() int {
+	return viper.GetInt("polygon.min_assets")
+}
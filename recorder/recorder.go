@@ -0,0 +1,257 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package recorder captures http requests made to data providers to disk and
+// replays them later so an import can be reproduced without calling the
+// provider APIs.
+package recorder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	scrubbed = "<scrubbed>"
+)
+
+var (
+	// ErrNotRecorded is returned in replay mode when no recording matches a request
+	ErrNotRecorded = errors.New("no recording found for request")
+
+	// scrubParams are query parameters that carry credentials
+	scrubParams = []string{"apiKey", "apikey", "token"}
+
+	// scrubHeaders are request headers that carry credentials
+	scrubHeaders = []string{"Authorization", "X-Openfigi-Apikey"}
+)
+
+// Interaction is a single recorded request and the response that was received
+type Interaction struct {
+	Method         string      `json:"method"`
+	Url            string      `json:"url"`
+	RequestHeader  http.Header `json:"request_header"`
+	RequestBody    []byte      `json:"request_body"`
+	StatusCode     int         `json:"status_code"`
+	ResponseHeader http.Header `json:"response_header"`
+	ResponseBody   []byte      `json:"response_body"`
+}
+
+// Recorder is an http.RoundTripper that saves each request and response in
+// `dir`. Credentials in the query string, headers, and any of `secrets` found
+// in the bodies are scrubbed before writing.
+type Recorder struct {
+	dir       string
+	secrets   []string
+	transport http.RoundTripper
+}
+
+// Replayer is an http.RoundTripper that answers requests from recordings in
+// `dir`. Requests with no matching recording fail with ErrNotRecorded.
+type Replayer struct {
+	dir     string
+	secrets []string
+}
+
+// NewRecorder creates a recorder that passes requests to `transport` and
+// saves them to `dir`. If transport is nil http.DefaultTransport is used.
+func NewRecorder(dir string, transport http.RoundTripper, secrets ...string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &Recorder{
+		dir:       dir,
+		secrets:   nonEmpty(secrets),
+		transport: transport,
+	}, nil
+}
+
+// NewReplayer creates a replayer that reads recordings from `dir`
+func NewReplayer(dir string, secrets ...string) (*Replayer, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &Replayer{
+		dir:     dir,
+		secrets: nonEmpty(secrets),
+	}, nil
+}
+
+// RoundTrip executes the request and records the interaction
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := readBody(&resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	interaction := &Interaction{
+		Method:         req.Method,
+		Url:            scrubUrl(req.URL, r.secrets).String(),
+		RequestHeader:  scrubHeader(req.Header),
+		RequestBody:    scrubBytes(reqBody, r.secrets),
+		StatusCode:     resp.StatusCode,
+		ResponseHeader: resp.Header.Clone(),
+		ResponseBody:   scrubBytes(respBody, r.secrets),
+	}
+
+	fn := filepath.Join(r.dir, key(req, reqBody, r.secrets)+".json")
+	data, err := json.MarshalIndent(interaction, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(fn, data, 0644); err != nil {
+		log.Error().Err(err).Str("FileName", fn).Msg("could not save recorded request")
+		return nil, err
+	}
+
+	log.Debug().Str("Url", interaction.Url).Str("FileName", fn).Msg("recorded request")
+	return resp, nil
+}
+
+// RoundTrip answers the request from the recording matching it
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	fn := filepath.Join(r.dir, key(req, reqBody, r.secrets)+".json")
+	subLog := log.With().Str("Url", scrubUrl(req.URL, r.secrets).String()).Str("FileName", fn).Logger()
+
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			subLog.Error().Msg("request was not recorded")
+			return nil, fmt.Errorf("%w: %s %s", ErrNotRecorded, req.Method, scrubUrl(req.URL, r.secrets))
+		}
+		return nil, err
+	}
+
+	interaction := Interaction{}
+	if err := json.Unmarshal(data, &interaction); err != nil {
+		subLog.Error().Err(err).Msg("could not parse recorded request")
+		return nil, err
+	}
+
+	subLog.Debug().Msg("replayed request")
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.StatusCode, http.StatusText(interaction.StatusCode)),
+		StatusCode:    interaction.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        interaction.ResponseHeader,
+		Body:          io.NopCloser(bytes.NewReader(interaction.ResponseBody)),
+		ContentLength: int64(len(interaction.ResponseBody)),
+		Request:       req,
+	}, nil
+}
+
+// key identifies a request independent of the host it was sent to (so
+// recordings can be replayed against any base url) and of any credentials
+func key(req *http.Request, body []byte, secrets []string) string {
+	u := scrubUrl(req.URL, secrets)
+	h := sha256.New()
+	h.Write([]byte(req.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(u.Path))
+	h.Write([]byte{0})
+	h.Write([]byte(u.Query().Encode()))
+	h.Write([]byte{0})
+	h.Write(scrubBytes(body, secrets))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// readBody reads and replaces `body` so it can be read again by the caller
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	data, err := io.ReadAll(*body)
+	(*body).Close()
+	if err != nil {
+		return nil, err
+	}
+	*body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+func scrubUrl(u *url.URL, secrets []string) *url.URL {
+	clean := *u
+	query := clean.Query()
+	for _, param := range scrubParams {
+		if query.Has(param) {
+			query.Set(param, scrubbed)
+		}
+	}
+	clean.RawQuery = query.Encode()
+	clean.RawQuery = string(scrubBytes([]byte(clean.RawQuery), secrets))
+	return &clean
+}
+
+func scrubHeader(header http.Header) http.Header {
+	clean := header.Clone()
+	for _, name := range scrubHeaders {
+		if clean.Get(name) != "" {
+			clean.Set(name, scrubbed)
+		}
+	}
+	return clean
+}
+
+func scrubBytes(data []byte, secrets []string) []byte {
+	for _, secret := range secrets {
+		data = bytes.ReplaceAll(data, []byte(secret), []byte(scrubbed))
+	}
+	return data
+}
+
+func nonEmpty(secrets []string) []string {
+	res := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		if strings.TrimSpace(secret) != "" && secret != "<not-set>" {
+			res = append(res, secret)
+		}
+	}
+	return res
+}
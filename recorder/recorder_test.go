@@ -0,0 +1,129 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recorder
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const secret = "secret-token"
+
+func readAll(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("could not read response body: %v", err)
+	}
+	return string(body)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"path":"` + r.URL.Path + `","body":` + string(body) + `}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	rec, err := NewRecorder(dir, nil, secret)
+	if err != nil {
+		t.Fatalf("NewRecorder failed: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/v3/mapping?apiKey="+secret, strings.NewReader(`{"key":"`+secret+`"}`))
+	req.Header.Set("X-OPENFIGI-APIKEY", secret)
+	resp, err := (&http.Client{Transport: rec}).Do(req)
+	if err != nil {
+		t.Fatalf("recorded request failed: %v", err)
+	}
+	recorded := readAll(t, resp)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("recorded %d files, want 1", len(files))
+	}
+	data, _ := os.ReadFile(files[0])
+	if bytes.Contains(data, []byte(secret)) {
+		t.Errorf("recording contains the secret: %s", data)
+	}
+
+	replayer, err := NewReplayer(dir, "another-token")
+	if err != nil {
+		t.Fatalf("NewReplayer failed: %v", err)
+	}
+
+	// recordings match any host and credentials
+	req, _ = http.NewRequest(http.MethodPost, "https://api.openfigi.com/v3/mapping?apiKey=another-token", strings.NewReader(`{"key":"another-token"}`))
+	resp, err = (&http.Client{Transport: replayer}).Do(req)
+	if err != nil {
+		t.Fatalf("replayed request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("replayed status = %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("replayed content type = %q", resp.Header.Get("Content-Type"))
+	}
+	if replayed := readAll(t, resp); replayed != strings.ReplaceAll(recorded, secret, scrubbed) {
+		t.Errorf("replayed body = %s, want %s", replayed, recorded)
+	}
+}
+
+func TestReplayFixture(t *testing.T) {
+	replayer, err := NewReplayer(filepath.Join("testdata", "replay"), "fixture-token")
+	if err != nil {
+		t.Fatalf("NewReplayer failed: %v", err)
+	}
+
+	resp, err := (&http.Client{Transport: replayer}).Get("https://api.polygon.io/v3/reference/tickers/AAPL?apiKey=fixture-token")
+	if err != nil {
+		t.Fatalf("replayed request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+	if body := readAll(t, resp); !strings.Contains(body, `"composite_figi":"BBG000B9XRY4"`) {
+		t.Errorf("body = %s, want the recorded AAPL details", body)
+	}
+}
+
+func TestReplayNotRecorded(t *testing.T) {
+	replayer, err := NewReplayer(filepath.Join("testdata", "replay"))
+	if err != nil {
+		t.Fatalf("NewReplayer failed: %v", err)
+	}
+
+	_, err = (&http.Client{Transport: replayer}).Get("https://api.polygon.io/v3/reference/tickers/MSFT")
+	if !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("err = %v, want ErrNotRecorded", err)
+	}
+}
+
+func TestNewReplayerMissingDir(t *testing.T) {
+	if _, err := NewReplayer(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("NewReplayer should fail for a missing directory")
+	}
+}
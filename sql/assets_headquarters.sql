@@ -0,0 +1,8 @@
+-- Columns for the headquarters location built from the polygon ticker detail
+-- address. Run once against an existing database before upgrading.
+
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS headquarters_location    TEXT;
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS headquarters_city        TEXT;
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS headquarters_state       TEXT;
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS headquarters_postal_code TEXT;
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS headquarters_country     TEXT;
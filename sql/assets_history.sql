@@ -0,0 +1,37 @@
+-- assets_history records every version of an asset saved by import-tickers.
+-- Each row is valid from `valid_from` (inclusive) until `valid_to`
+-- (exclusive); the current version of an asset has a NULL `valid_to`.
+--
+-- Example: what did ticker XYZ look like on 2022-01-03?
+--
+--   SELECT * FROM assets_history
+--   WHERE ticker = 'XYZ'
+--     AND valid_from <= '2022-01-03'
+--     AND (valid_to IS NULL OR valid_to > '2022-01-03');
+
+CREATE TABLE IF NOT EXISTS assets_history (
+    ticker           TEXT NOT NULL,
+    asset_type       TEXT NOT NULL,
+    name             TEXT,
+    description      TEXT,
+    primary_exchange TEXT,
+    composite_figi   TEXT,
+    share_class_figi TEXT,
+    cusip            TEXT,
+    isin             TEXT,
+    cik              TEXT,
+    sector           TEXT,
+    industry         TEXT,
+    corporate_url    TEXT,
+    logo_url         TEXT,
+    listed_utc       TIMESTAMP,
+    delisted_utc     TIMESTAMP,
+    active           BOOLEAN NOT NULL,
+    source           TEXT,
+    valid_from       TIMESTAMPTZ NOT NULL,
+    valid_to         TIMESTAMPTZ,
+    PRIMARY KEY (ticker, asset_type, valid_from)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS assets_history_current_idx ON assets_history (ticker, asset_type) WHERE valid_to IS NULL;
+CREATE INDEX IF NOT EXISTS assets_history_composite_figi_idx ON assets_history (composite_figi, valid_from);
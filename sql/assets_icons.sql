@@ -0,0 +1,6 @@
+-- Columns for the content address (sha256 of the image followed by its file
+-- extension) of icons and logos saved to the `icons/` directory of the
+-- backblaze bucket. Run once against an existing database before upgrading.
+
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS icon_hash TEXT;
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS logo_hash TEXT;
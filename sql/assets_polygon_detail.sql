@@ -0,0 +1,11 @@
+-- Columns for company details downloaded from the polygon ticker detail
+-- endpoint. Run once against an existing database before upgrading.
+
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS market_cap                     DOUBLE PRECISION;
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS sic_code                       TEXT;
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS sic_description                TEXT;
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS total_employees                BIGINT;
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS phone_number                   TEXT;
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS address                        TEXT;
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS share_class_shares_outstanding BIGINT;
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS weighted_shares_outstanding    BIGINT;
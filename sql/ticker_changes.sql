@@ -0,0 +1,21 @@
+-- ticker_changes records each time a security began trading under a new
+-- ticker. Changes are collected from polygon ticker events and from renames
+-- detected by import-tickers.
+--
+-- Example: all tickers used by a security
+--
+--   SELECT old_ticker, new_ticker, event_date FROM ticker_changes
+--   WHERE composite_figi = 'BBG000MM2P62'
+--   ORDER BY event_date;
+
+CREATE TABLE IF NOT EXISTS ticker_changes (
+    composite_figi TEXT NOT NULL,
+    old_ticker     TEXT NOT NULL,
+    new_ticker     TEXT NOT NULL,
+    event_date     DATE NOT NULL,
+    source         TEXT,
+    PRIMARY KEY (composite_figi, old_ticker, new_ticker)
+);
+
+CREATE INDEX IF NOT EXISTS ticker_changes_old_ticker_idx ON ticker_changes (old_ticker);
+CREATE INDEX IF NOT EXISTS ticker_changes_new_ticker_idx ON ticker_changes (new_ticker);
@@ -0,0 +1,221 @@
+package tiingo
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	cacheArchiveFile     = "supported_tickers.zip"
+	cacheMetaFile        = "supported_tickers.json"
+	cacheCsvFile         = "supported_tickers.csv"
+	cachePreviousCsvFile = "supported_tickers.prev.csv"
+)
+
+// Report summarizes the last download of supported_tickers.zip
+type Report struct {
+	// NotModified is true when tiingo reported the archive unchanged and the
+	// cached copy was used
+	NotModified bool        `json:"not_modified"`
+	Dropped     *DropReport `json:"dropped"`
+	Changes     *TickerDiff `json:"changes,omitempty"`
+}
+
+// cacheMeta holds the validators of the cached archive used to make
+// conditional requests
+type cacheMeta struct {
+	ETag         string `json:"etag"`
+	LastModified string `json:"last_modified"`
+	Downloaded   int64  `json:"downloaded"`
+}
+
+// tickerCache keeps the last downloaded supported_tickers.zip along with its
+// csv and the csv of the download before it
+type tickerCache struct {
+	dir string
+}
+
+// newTickerCache returns a cache stored in `dir` or nil if `dir` is empty
+func newTickerCache(dir string) *tickerCache {
+	if dir == "" {
+		return nil
+	}
+	return &tickerCache{dir: dir}
+}
+
+func (cache *tickerCache) path(name string) string {
+	return filepath.Join(cache.dir, name)
+}
+
+// loadMeta returns the validators of the cached archive; they are empty if
+// nothing has been cached
+func (cache *tickerCache) loadMeta() *cacheMeta {
+	meta := &cacheMeta{}
+	if _, err := os.Stat(cache.path(cacheArchiveFile)); err != nil {
+		return meta
+	}
+
+	data, err := os.ReadFile(cache.path(cacheMetaFile))
+	if err != nil {
+		return meta
+	}
+	if err := json.Unmarshal(data, meta); err != nil {
+		log.Warn().Err(err).Str("FileName", cache.path(cacheMetaFile)).Msg("ignoring invalid tiingo cache metadata")
+		return &cacheMeta{}
+	}
+	return meta
+}
+
+// archive returns the cached supported_tickers.zip
+func (cache *tickerCache) archive() ([]byte, error) {
+	return os.ReadFile(cache.path(cacheArchiveFile))
+}
+
+// saveArchive stores a newly downloaded archive and its validators
+func (cache *tickerCache) saveArchive(body []byte, meta *cacheMeta) {
+	if err := os.MkdirAll(cache.dir, 0755); err != nil {
+		log.Error().Err(err).Str("Dir", cache.dir).Msg("could not create tiingo cache directory")
+		return
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		log.Error().Err(err).Msg("could not marshal tiingo cache metadata")
+		return
+	}
+
+	if err := writeFileAtomic(cache.path(cacheArchiveFile), body); err != nil {
+		log.Error().Err(err).Str("FileName", cache.path(cacheArchiveFile)).Msg("could not save tiingo archive")
+		return
+	}
+	if err := writeFileAtomic(cache.path(cacheMetaFile), data); err != nil {
+		log.Error().Err(err).Str("FileName", cache.path(cacheMetaFile)).Msg("could not save tiingo cache metadata")
+	}
+}
+
+// update saves `csv` as the current csv, keeping the csv it replaces as the
+// previous csv, and returns the difference between the two. nil is returned
+// when there is no previous csv to compare against.
+func (cache *tickerCache) update(csv []byte, rows []*TiingoAsset) *TickerDiff {
+	current := cache.path(cacheCsvFile)
+	previous := cache.path(cachePreviousCsvFile)
+
+	var diff *TickerDiff
+	if prevCsv, err := os.ReadFile(current); err == nil {
+		if prevRows, err := parseSupportedTickers(prevCsv); err == nil {
+			diff = DiffTickers(prevRows, rows)
+		}
+		if err := os.Rename(current, previous); err != nil {
+			log.Error().Err(err).Str("FileName", previous).Msg("could not keep previous tiingo csv")
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Error().Err(err).Str("FileName", current).Msg("could not read previous tiingo csv")
+	} else {
+		log.Info().Str("FileName", current).Msg("no previous tiingo csv to compare against")
+	}
+
+	if err := writeFileAtomic(current, csv); err != nil {
+		log.Error().Err(err).Str("FileName", current).Msg("could not save tiingo csv")
+	}
+
+	return diff
+}
+
+func writeFileAtomic(fn string, data []byte) error {
+	tmp := fn + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fn)
+}
+
+// EndDateChange is a row of supported_tickers.csv whose end date changed
+type EndDateChange struct {
+	Ticker   string `json:"ticker"`
+	Exchange string `json:"exchange"`
+	Old      string `json:"old"`
+	New      string `json:"new"`
+}
+
+// TickerDiff lists the rows of supported_tickers.csv that changed between
+// two downloads. Rows are identified by ticker and exchange.
+type TickerDiff struct {
+	Added          []string         `json:"added"`
+	Removed        []string         `json:"removed"`
+	EndDateChanged []*EndDateChange `json:"end_date_changed"`
+}
+
+// DiffTickers compares the rows of two downloads of supported_tickers.csv
+func DiffTickers(previous, current []*TiingoAsset) *TickerDiff {
+	diff := &TickerDiff{
+		Added:          []string{},
+		Removed:        []string{},
+		EndDateChanged: []*EndDateChange{},
+	}
+
+	key := func(asset *TiingoAsset) string {
+		return asset.Ticker + ":" + asset.Exchange
+	}
+
+	previousMap := make(map[string]*TiingoAsset, len(previous))
+	for _, asset := range previous {
+		previousMap[key(asset)] = asset
+	}
+
+	currentMap := make(map[string]*TiingoAsset, len(current))
+	for _, asset := range current {
+		currentMap[key(asset)] = asset
+		old, ok := previousMap[key(asset)]
+		if !ok {
+			diff.Added = append(diff.Added, key(asset))
+			continue
+		}
+		if old.EndDate != asset.EndDate {
+			diff.EndDateChanged = append(diff.EndDateChanged, &EndDateChange{
+				Ticker:   asset.Ticker,
+				Exchange: asset.Exchange,
+				Old:      old.EndDate,
+				New:      asset.EndDate,
+			})
+		}
+	}
+
+	for _, asset := range previous {
+		if _, ok := currentMap[key(asset)]; !ok {
+			diff.Removed = append(diff.Removed, key(asset))
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Slice(diff.EndDateChanged, func(i, j int) bool {
+		return diff.EndDateChanged[i].Ticker < diff.EndDateChanged[j].Ticker
+	})
+
+	return diff
+}
+
+// Log writes the size of the diff to the log; the individual rows are logged
+// at debug level
+func (diff *TickerDiff) Log() {
+	log.Info().
+		Int("Added", len(diff.Added)).
+		Int("Removed", len(diff.Removed)).
+		Int("EndDateChanged", len(diff.EndDateChanged)).
+		Msg("tiingo supported tickers changed since last download")
+
+	for _, row := range diff.Added {
+		log.Debug().Str("Row", row).Msg("tiingo row added")
+	}
+	for _, row := range diff.Removed {
+		log.Debug().Str("Row", row).Msg("tiingo row removed")
+	}
+	for _, change := range diff.EndDateChanged {
+		log.Debug().Str("Ticker", change.Ticker).Str("Exchange", change.Exchange).Str("Old", change.Old).Str("New", change.New).Msg("tiingo end date changed")
+	}
+}
@@ -0,0 +1,150 @@
+package tiingo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/penny-vault/import-tickers/common"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// DefaultExchanges is the list of exchanges kept when tiingo.exchanges is not set
+var DefaultExchanges = []string{"AMEX", "BATS", "NASDAQ", "NMFQS", "NYSE", "NYSE ARCA", "NYSE MKT"}
+
+// DefaultAssetTypes maps tiingo asset types to asset types when
+// tiingo.asset_types is not set
+var DefaultAssetTypes = map[string]common.AssetType{
+	"Stock":       common.CommonStock,
+	"ETF":         common.ETF,
+	"Mutual Fund": common.MutualFund,
+}
+
+// DefaultEndDateTolerance is how long after its end date a ticker is still
+// considered listed when tiingo.end_date_tolerance is not set
+const DefaultEndDateTolerance = 7 * 24 * time.Hour
+
+// Config controls which rows of supported_tickers.zip are imported
+type Config struct {
+	// Exchanges rows must be listed on one of
+	Exchanges []string
+
+	// AssetTypes maps tiingo asset types (lower case) to asset types; rows
+	// with unmapped types are dropped
+	AssetTypes map[string]common.AssetType
+
+	// EndDateTolerance is how recent an end date may be for the ticker to be
+	// considered listed; tiingo's end date lags the last trading day
+	EndDateTolerance time.Duration
+}
+
+// LoadConfig reads the tiingo.exchanges, tiingo.asset_types, and
+// tiingo.end_date_tolerance config settings. Asset types are matched without
+// regard to case because config keys are case insensitive.
+func LoadConfig() (*Config, error) {
+	config := &Config{
+		Exchanges:        DefaultExchanges,
+		AssetTypes:       make(map[string]common.AssetType),
+		EndDateTolerance: DefaultEndDateTolerance,
+	}
+
+	if viper.IsSet("tiingo.exchanges") {
+		config.Exchanges = viper.GetStringSlice("tiingo.exchanges")
+	}
+
+	if viper.IsSet("tiingo.asset_types") {
+		for tiingoType, value := range viper.GetStringMapString("tiingo.asset_types") {
+			assetType, err := common.ParseAssetType(value)
+			if err != nil {
+				return nil, fmt.Errorf("tiingo.asset_types.%s: %w", tiingoType, err)
+			}
+			config.AssetTypes[strings.ToLower(tiingoType)] = assetType
+		}
+	} else {
+		for tiingoType, assetType := range DefaultAssetTypes {
+			config.AssetTypes[strings.ToLower(tiingoType)] = assetType
+		}
+	}
+
+	if viper.IsSet("tiingo.end_date_tolerance") {
+		config.EndDateTolerance = viper.GetDuration("tiingo.end_date_tolerance")
+	}
+
+	return config, nil
+}
+
+// AllowExchange returns true if rows listed on `exchange` are imported
+func (config *Config) AllowExchange(exchange string) bool {
+	for _, allowed := range config.Exchanges {
+		if strings.EqualFold(allowed, exchange) {
+			return true
+		}
+	}
+	return false
+}
+
+// AssetType returns the asset type `tiingoType` is mapped to
+func (config *Config) AssetType(tiingoType string) (common.AssetType, bool) {
+	assetType, ok := config.AssetTypes[strings.ToLower(tiingoType)]
+	return assetType, ok
+}
+
+// DropReport counts the rows of supported_tickers.zip that were not imported
+// and why
+type DropReport struct {
+	Rows          int            `json:"rows"`
+	Kept          int            `json:"kept"`
+	ByExchange    map[string]int `json:"dropped_by_exchange"`
+	ByAssetType   map[string]int `json:"dropped_by_asset_type"`
+	NoDates       int            `json:"dropped_no_dates"`
+	IgnoredTicker int            `json:"dropped_ignored_ticker"`
+	Delisted      int            `json:"dropped_delisted"`
+}
+
+func newDropReport() *DropReport {
+	return &DropReport{
+		ByExchange:  make(map[string]int),
+		ByAssetType: make(map[string]int),
+	}
+}
+
+// Log writes the report to the log with the largest groups first
+func (report *DropReport) Log() {
+	log.Info().
+		Int("Rows", report.Rows).
+		Int("Kept", report.Kept).
+		Int("NoDates", report.NoDates).
+		Int("IgnoredTicker", report.IgnoredTicker).
+		Int("Delisted", report.Delisted).
+		Msg("tiingo supported tickers filtered")
+
+	for _, group := range []struct {
+		name   string
+		msg    string
+		counts map[string]int
+	}{
+		{"Exchange", "tiingo rows dropped on exchange not followed", report.ByExchange},
+		{"AssetType", "tiingo rows dropped with unmapped asset type", report.ByAssetType},
+	} {
+		for _, key := range sortedByCount(group.counts) {
+			log.Info().Str(group.name, key).Int("Dropped", group.counts[key]).Msg(group.msg)
+		}
+	}
+}
+
+// sortedByCount returns the keys of `counts` ordered by descending count
+func sortedByCount(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] == counts[keys[j]] {
+			return keys[i] < keys[j]
+		}
+		return counts[keys[i]] > counts[keys[j]]
+	})
+	return keys
+}
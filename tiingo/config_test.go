@@ -0,0 +1,123 @@
+package tiingo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/penny-vault/import-tickers/common"
+	"github.com/spf13/viper"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	defer viper.Reset()
+
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if len(config.Exchanges) != len(DefaultExchanges) {
+		t.Errorf("exchanges = %v, want %v", config.Exchanges, DefaultExchanges)
+	}
+	if assetType, ok := config.AssetType("mutual fund"); !ok || assetType != common.MutualFund {
+		t.Errorf("AssetType(mutual fund) = %q, %t, want %q", assetType, ok, common.MutualFund)
+	}
+	if _, ok := config.AssetType("Warrant"); ok {
+		t.Error("Warrant should not be mapped by default")
+	}
+	if config.EndDateTolerance != DefaultEndDateTolerance {
+		t.Errorf("EndDateTolerance = %s, want %s", config.EndDateTolerance, DefaultEndDateTolerance)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	defer viper.Reset()
+
+	viper.Set("tiingo.exchanges", []string{"NYSE", "nasdaq"})
+	viper.Set("tiingo.asset_types", map[string]string{"Stock": "common stock", "ETF": "Exchange Traded Note"})
+	viper.Set("tiingo.end_date_tolerance", "48h")
+
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	for exchange, want := range map[string]bool{"NYSE": true, "NASDAQ": true, "nyse": true, "NMFQS": false} {
+		if got := config.AllowExchange(exchange); got != want {
+			t.Errorf("AllowExchange(%s) = %t, want %t", exchange, got, want)
+		}
+	}
+	if assetType, ok := config.AssetType("STOCK"); !ok || assetType != common.CommonStock {
+		t.Errorf("AssetType(STOCK) = %q, %t, want %q", assetType, ok, common.CommonStock)
+	}
+	if assetType, ok := config.AssetType("etf"); !ok || assetType != common.ETN {
+		t.Errorf("AssetType(etf) = %q, %t, want %q", assetType, ok, common.ETN)
+	}
+	if _, ok := config.AssetType("Mutual Fund"); ok {
+		t.Error("Mutual Fund should not be mapped when tiingo.asset_types is set")
+	}
+	if config.EndDateTolerance != 48*time.Hour {
+		t.Errorf("EndDateTolerance = %s, want 48h", config.EndDateTolerance)
+	}
+}
+
+func TestLoadConfigInvalidAssetType(t *testing.T) {
+	defer viper.Reset()
+
+	viper.Set("tiingo.asset_types", map[string]string{"Stock": "Shares"})
+	if _, err := LoadConfig(); err == nil {
+		t.Error("LoadConfig should fail for an unknown asset type")
+	}
+}
+
+func TestFilterAssets(t *testing.T) {
+	config := &Config{
+		Exchanges:        []string{"NYSE", "NMFQS"},
+		AssetTypes:       map[string]common.AssetType{"stock": common.CommonStock, "mutual fund": common.MutualFund},
+		EndDateTolerance: 7 * 24 * time.Hour,
+	}
+	recent := time.Now().AddDate(0, 0, -2).Format("2006-01-02")
+	rows := []*TiingoAsset{
+		{Ticker: "BRK-B", Exchange: "NYSE", AssetType: "Stock", StartDate: "1996-05-09", EndDate: recent},
+		{Ticker: "VFIAX", Exchange: "NMFQS", AssetType: "Mutual Fund", StartDate: "2000-11-13"},
+		{Ticker: "OLD", Exchange: "NYSE", AssetType: "Stock", StartDate: "1990-01-02", EndDate: "2010-06-30"},
+		{Ticker: "LSE", Exchange: "LSE", AssetType: "Stock", StartDate: "2001-01-02"},
+		{Ticker: "OTC1", Exchange: "OTC", AssetType: "Stock", StartDate: "2001-01-02"},
+		{Ticker: "WARR", Exchange: "NYSE", AssetType: "Warrant", StartDate: "2001-01-02"},
+		{Ticker: "NODT", Exchange: "NYSE", AssetType: "Stock"},
+		{Ticker: "ABCD-W", Exchange: "NYSE", AssetType: "Stock", StartDate: "2001-01-02"},
+	}
+
+	assets, report := filterAssets(config, rows)
+	if len(assets) != 2 {
+		t.Fatalf("kept %d assets, want 2", len(assets))
+	}
+	if assets[0].Ticker != "BRK/B" || assets[0].DelistingDate != "" || assets[0].AssetType != common.CommonStock {
+		t.Errorf("asset 0 = %+v, want listed BRK/B common stock", assets[0])
+	}
+	if assets[1].Ticker != "VFIAX" || assets[1].AssetType != common.MutualFund || assets[1].ListingDate != "2000-11-13" {
+		t.Errorf("asset 1 = %+v, want VFIAX mutual fund", assets[1])
+	}
+
+	if report.Rows != len(rows) || report.Kept != 2 {
+		t.Errorf("report rows = %d kept = %d, want %d and 2", report.Rows, report.Kept, len(rows))
+	}
+	if report.ByExchange["LSE"] != 1 || report.ByExchange["OTC"] != 1 {
+		t.Errorf("dropped by exchange = %v", report.ByExchange)
+	}
+	if report.ByAssetType["Warrant"] != 1 {
+		t.Errorf("dropped by asset type = %v", report.ByAssetType)
+	}
+	if report.NoDates != 1 || report.IgnoredTicker != 1 || report.Delisted != 1 {
+		t.Errorf("report = %+v, want one row each without dates, ignored, and delisted", report)
+	}
+}
+
+func TestSortedByCount(t *testing.T) {
+	keys := sortedByCount(map[string]int{"OTC": 3, "LSE": 5, "BATS": 3})
+	want := []string{"LSE", "BATS", "OTC"}
+	for ii := range want {
+		if keys[ii] != want[ii] {
+			t.Fatalf("sortedByCount = %v, want %v", keys, want)
+		}
+	}
+}
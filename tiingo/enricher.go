@@ -0,0 +1,24 @@
+package tiingo
+
+import (
+	"context"
+
+	"github.com/penny-vault/import-tickers/common"
+)
+
+func init() {
+	common.RegisterEnricher(&MetaEnricher{})
+}
+
+// MetaEnricher fills the name, description, and exchange of mutual funds from
+// tiingo's daily meta data endpoint. It requires tiingo.token.
+type MetaEnricher struct{}
+
+func (e *MetaEnricher) Name() string {
+	return "tiingo"
+}
+
+func (e *MetaEnricher) Enrich(ctx context.Context, assets []*common.Asset, max int) []*common.Asset {
+	EnrichMeta(ctx, assets, max)
+	return assets
+}
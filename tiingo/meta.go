@@ -0,0 +1,156 @@
+package tiingo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/penny-vault/import-tickers/common"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+)
+
+const (
+	DefaultBaseUrl = "https://api.tiingo.com"
+)
+
+var ErrNoToken = errors.New("tiingo.token is not set")
+
+// TiingoMeta is the response of tiingo's daily meta data endpoint
+type TiingoMeta struct {
+	Ticker       string `json:"ticker"`
+	Name         string `json:"name"`
+	ExchangeCode string `json:"exchangeCode"`
+	Description  string `json:"description"`
+	StartDate    string `json:"startDate"`
+	EndDate      string `json:"endDate"`
+}
+
+// baseUrl returns the tiingo API root, which may be overridden with the
+// tiingo.base_url config setting
+func baseUrl() string {
+	if url := viper.GetString("tiingo.base_url"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return DefaultBaseUrl
+}
+
+func token() string {
+	token := viper.GetString("tiingo.token")
+	if token == "<not-set>" {
+		return ""
+	}
+	return token
+}
+
+func rateLimit() *rate.Limiter {
+	dur := time.Duration(int64(time.Second) * 60 / viper.GetInt64("tiingo.rate_limit"))
+	tiingoRate := rate.Every(dur)
+	return rate.NewLimiter(tiingoRate, 2)
+}
+
+// NeedsMeta returns true if the asset is a mutual fund that is missing data
+// available from tiingo's meta data endpoint
+func NeedsMeta(asset *common.Asset) bool {
+	return asset.AssetType == common.MutualFund && asset.DelistingDate == "" &&
+		(asset.Name == "" || asset.Description == "" || asset.PrimaryExchange == "")
+}
+
+// EnrichMeta fills the name, description, and exchange of at most `max`
+// mutual funds (0 means no limit) from tiingo's daily meta data endpoint.
+// Lookups run concurrently using tiingo.workers workers.
+func EnrichMeta(ctx context.Context, assets []*common.Asset, max int) {
+	if token() == "" {
+		log.Warn().Err(ErrNoToken).Msg("skipping tiingo meta data enrichment")
+		return
+	}
+
+	pending := make([]*common.Asset, 0)
+	for _, asset := range assets {
+		if NeedsMeta(asset) {
+			pending = append(pending, asset)
+		}
+	}
+
+	if max > 0 && len(pending) > max {
+		pending = pending[:max]
+	}
+
+	log.Info().Int("NumAssets", len(pending)).Msg("fetching tiingo meta data")
+
+	pool := common.NewWorkerPool("tiingo", rateLimit())
+	common.RunPool(ctx, pool, pending, func(ctx context.Context, asset *common.Asset) error {
+		meta, err := FetchMeta(ctx, asset.Ticker)
+		if err != nil {
+			return err
+		}
+		applyMeta(asset, meta)
+		return nil
+	})
+}
+
+// FetchMeta retrieves the daily meta data of `ticker` from tiingo
+func FetchMeta(ctx context.Context, ticker string) (*TiingoMeta, error) {
+	if token() == "" {
+		return nil, ErrNoToken
+	}
+
+	ticker = strings.ReplaceAll(ticker, "/", "-")
+	urlClean := fmt.Sprintf("%s/tiingo/daily/%s", baseUrl(), url.PathEscape(ticker))
+	subLog := log.With().Str("Url", urlClean).Str("Source", "api.tiingo.com").Logger()
+
+	client := newClient()
+	resp, err := client.R().
+		SetContext(ctx).
+		SetHeader("Authorization", fmt.Sprintf("Token %s", token())).
+		SetHeader("Content-Type", "application/json").
+		Get(urlClean)
+	if err != nil {
+		subLog.Error().Err(err).Msg("error when fetching tiingo meta data")
+		return nil, err
+	}
+
+	if resp.StatusCode() >= 400 {
+		subLog.Error().Int("StatusCode", resp.StatusCode()).Msg("error code received from server when fetching meta data")
+		return nil, fmt.Errorf("tiingo returned status code %d", resp.StatusCode())
+	}
+
+	meta := &TiingoMeta{}
+	if err := json.Unmarshal(resp.Body(), meta); err != nil {
+		subLog.Error().Err(err).Msg("could not unmarshal response body when fetching meta data")
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+// applyMeta copies the non-empty fields of `meta` to `asset`
+func applyMeta(asset *common.Asset, meta *TiingoMeta) {
+	updated := false
+	for _, field := range []struct {
+		name  string
+		dest  *string
+		value string
+	}{
+		{"Name", &asset.Name, strings.TrimSpace(meta.Name)},
+		{"Description", &asset.Description, strings.TrimSpace(meta.Description)},
+		{"PrimaryExchange", &asset.PrimaryExchange, strings.TrimSpace(meta.ExchangeCode)},
+	} {
+		if field.value == "" || *field.dest == field.value {
+			continue
+		}
+		asset.RecordChange(field.name, *field.dest, field.value, "api.tiingo.com")
+		*field.dest = field.value
+		updated = true
+	}
+
+	if updated {
+		asset.Updated = true
+		asset.LastUpdated = time.Now().Unix()
+	}
+}
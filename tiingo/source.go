@@ -0,0 +1,44 @@
+package tiingo
+
+import (
+	"context"
+
+	"github.com/penny-vault/import-tickers/common"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	common.RegisterSource(&Source{})
+}
+
+// Source provides mutual funds (and any other supported tickers) from tiingo
+type Source struct {
+	report *Report
+}
+
+func (s *Source) Name() string {
+	return "tiingo"
+}
+
+func (s *Source) FetchAssets(ctx context.Context) ([]*common.Asset, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var assets []*common.Asset
+	assets, s.report = FetchAssets(ctx, config)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return assets, nil
+}
+
+// Report returns the summary of the last download
+func (s *Source) Report() interface{} {
+	return s.report
+}
+
+func (s *Source) MinAssets() int {
+	return viper.GetInt("tiingo.min_assets")
+}
@@ -3,7 +3,11 @@ package tiingo
 import (
 	"archive/zip"
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net/http"
 	"regexp"
 	"strings"
 	"time"
@@ -12,8 +16,15 @@ import (
 	"github.com/gocarina/gocsv"
 	"github.com/penny-vault/import-tickers/common"
 	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
 )
 
+const (
+	DefaultMediaBaseUrl = "https://apimedia.tiingo.com"
+)
+
+var httpClient *http.Client
+
 type TiingoAsset struct {
 	Ticker        string `json:"ticker" csv:"ticker"`
 	Exchange      string `json:"exchange" csv:"exchange"`
@@ -23,6 +34,29 @@ type TiingoAsset struct {
 	EndDate       string `json:"endDate" csv:"endDate"`
 }
 
+// SetHTTPClient sets the http client used for all requests made to tiingo.
+// Passing nil restores the default client.
+func SetHTTPClient(client *http.Client) {
+	httpClient = client
+}
+
+func newClient() *resty.Client {
+	client := resty.New()
+	if httpClient != nil {
+		client = resty.NewWithClient(httpClient)
+	}
+	return common.NewRetryPolicy("tiingo").Apply(client)
+}
+
+// mediaBaseUrl returns the host serving tiingo's static files, which may be
+// overridden with the tiingo.media_base_url config setting
+func mediaBaseUrl() string {
+	if url := viper.GetString("tiingo.media_base_url"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return DefaultMediaBaseUrl
+}
+
 func readZipFile(zf *zip.File) ([]byte, error) {
 	f, err := zf.Open()
 	if err != nil {
@@ -47,98 +81,175 @@ func ignoreTicker(ticker string) bool {
 	return ignore
 }
 
-// FetchAssets retrieves a list of supported tickers from Tiingo
-func FetchAssets() []*common.Asset {
-	tickerUrl := "https://apimedia.tiingo.com/docs/tiingo/daily/supported_tickers.zip"
-	client := resty.New()
-	assets := []*TiingoAsset{}
+// FetchAssets retrieves a list of supported tickers from Tiingo. Rows are
+// filtered and typed according to `config`. When tiingo.cache_dir is set the
+// download is skipped if the archive hasn't changed since the last run, and
+// the rows that changed are reported. Cancelling `ctx` aborts the download.
+func FetchAssets(ctx context.Context, config *Config) ([]*common.Asset, *Report) {
+	cache := newTickerCache(viper.GetString("tiingo.cache_dir"))
+	report := &Report{Dropped: newDropReport()}
+
+	body, notModified, err := downloadSupportedTickers(ctx, cache)
+	if err != nil {
+		return []*common.Asset{}, report
+	}
+	report.NotModified = notModified
+
+	tickerCsvBytes, err := unzipSupportedTickers(body)
+	if err != nil {
+		return []*common.Asset{}, report
+	}
 
-	resp, err := client.
-		R().
-		Get(tickerUrl)
+	assets, err := parseSupportedTickers(tickerCsvBytes)
+	if err != nil {
+		return []*common.Asset{}, report
+	}
+
+	if cache != nil {
+		if notModified {
+			report.Changes = DiffTickers(nil, nil)
+		} else {
+			report.Changes = cache.update(tickerCsvBytes, assets)
+		}
+		if report.Changes != nil {
+			report.Changes.Log()
+		}
+	}
+
+	commonAssets, dropped := filterAssets(config, assets)
+	report.Dropped = dropped
+	return commonAssets, report
+}
+
+// downloadSupportedTickers downloads supported_tickers.zip. If `cache` holds
+// a previous download the request is conditional and the cached archive is
+// returned when tiingo reports it is unchanged.
+func downloadSupportedTickers(ctx context.Context, cache *tickerCache) ([]byte, bool, error) {
+	tickerUrl := mediaBaseUrl() + "/docs/tiingo/daily/supported_tickers.zip"
+	client := newClient()
+	req := client.R().SetContext(ctx)
+
+	var meta *cacheMeta
+	if cache != nil {
+		meta = cache.loadMeta()
+		if meta.ETag != "" {
+			req.SetHeader("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.SetHeader("If-Modified-Since", meta.LastModified)
+		}
+	}
+
+	resp, err := req.Get(tickerUrl)
 	if err != nil {
 		log.Error().Str("OriginalError", err.Error()).Msg("failed to download tickers")
-		return []*common.Asset{}
+		return nil, false, err
+	}
+
+	if resp.StatusCode() == http.StatusNotModified && cache != nil {
+		body, err := cache.archive()
+		if err == nil {
+			log.Info().Str("ETag", meta.ETag).Str("LastModified", meta.LastModified).Msg("tiingo supported tickers not modified - using cached archive")
+			return body, true, nil
+		}
+		log.Warn().Err(err).Msg("could not read cached tiingo archive - downloading again")
+		resp, err = client.R().SetContext(ctx).Get(tickerUrl)
+		if err != nil {
+			log.Error().Str("OriginalError", err.Error()).Msg("failed to download tickers")
+			return nil, false, err
+		}
 	}
-	if resp.StatusCode() >= 400 {
+
+	if resp.StatusCode() >= 400 || resp.StatusCode() == http.StatusNotModified {
 		log.Error().Int("StatusCode", resp.StatusCode()).Str("Url", tickerUrl).Bytes("Body", resp.Body()).Msg("error when requesting eod quote")
-		return []*common.Asset{}
+		return nil, false, fmt.Errorf("tiingo returned status code %d", resp.StatusCode())
 	}
 
-	// unzip downloaded data
 	body := resp.Body()
-	if err != nil {
-		log.Error().Str("OriginalError", err.Error()).Msg("could not read response body when downloading tickers")
-		return []*common.Asset{}
+	if cache != nil {
+		cache.saveArchive(body, &cacheMeta{
+			ETag:         resp.Header().Get("ETag"),
+			LastModified: resp.Header().Get("Last-Modified"),
+			Downloaded:   time.Now().Unix(),
+		})
 	}
 
+	return body, false, nil
+}
+
+// unzipSupportedTickers extracts the csv file from supported_tickers.zip
+func unzipSupportedTickers(body []byte) ([]byte, error) {
 	zipReader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
 	if err != nil {
 		log.Error().Str("OriginalError", err.Error()).Msg("failed to read tickers zip file")
-		return []*common.Asset{}
+		return nil, err
 	}
 
 	// Read all the files from zip archive
-	var tickerCsvBytes []byte
 	if len(zipReader.File) == 0 {
 		log.Error().Msg("no files contained in received zip file")
-		return []*common.Asset{}
+		return nil, errors.New("no files in zip archive")
 	}
 
 	zipFile := zipReader.File[0]
-	tickerCsvBytes, err = readZipFile(zipFile)
+	tickerCsvBytes, err := readZipFile(zipFile)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to read ticker csv from zip")
-		return []*common.Asset{}
+		return nil, err
 	}
 
+	return tickerCsvBytes, nil
+}
+
+func parseSupportedTickers(tickerCsvBytes []byte) ([]*TiingoAsset, error) {
+	assets := []*TiingoAsset{}
 	if err := gocsv.UnmarshalBytes(tickerCsvBytes, &assets); err != nil {
 		log.Error().Err(err).Msg("failed to unmarshal csv")
-		return []*common.Asset{}
+		return nil, err
 	}
+	return assets, nil
+}
 
-	validExchanges := []string{"AMEX", "BATS", "NASDAQ", "NMFQS", "NYSE", "NYSE ARCA", "NYSE MKT"}
+// filterAssets converts the rows of supported_tickers.csv to assets, keeping
+// only the rows selected by `config`
+func filterAssets(config *Config, assets []*TiingoAsset) ([]*common.Asset, *DropReport) {
+	report := newDropReport()
+	report.Rows = len(assets)
 	commonAssets := make([]*common.Asset, 0, 25000)
 	for _, asset := range assets {
-		// remove assets on invalid exchanges
-		keep := false
-		for _, exchange := range validExchanges {
-			if asset.Exchange == exchange {
-				keep = true
-			}
-		}
-		if !keep {
+		// remove assets on exchanges that aren't followed
+		if !config.AllowExchange(asset.Exchange) {
+			report.ByExchange[asset.Exchange]++
 			continue
 		}
 
 		// If both the start date and end date are not set skip it
 		if asset.StartDate == "" && asset.EndDate == "" {
+			report.NoDates++
 			continue
 		}
 
 		// filter out tickers we should ignore
 		if ignoreTicker(asset.Ticker) {
+			report.IgnoredTicker++
+			continue
+		}
+
+		assetType, ok := config.AssetType(asset.AssetType)
+		if !ok {
+			report.ByAssetType[asset.AssetType]++
 			continue
 		}
 
-		asset.Ticker = strings.ReplaceAll(asset.Ticker, "-", "/")
 		myAsset := &common.Asset{
-			Ticker:          asset.Ticker,
+			Ticker:          strings.ReplaceAll(asset.Ticker, "-", "/"),
 			ListingDate:     asset.StartDate,
 			DelistingDate:   asset.EndDate,
 			PrimaryExchange: asset.Exchange,
+			AssetType:       assetType,
 			Source:          "api.tiingo.com",
 		}
 
-		switch asset.AssetType {
-		case "Stock":
-			myAsset.AssetType = common.CommonStock
-		case "ETF":
-			myAsset.AssetType = common.ETF
-		case "Mutual Fund":
-			myAsset.AssetType = common.MutualFund
-		}
-
 		if asset.EndDate != "" {
 			endDate, err := time.Parse("2006-01-02", asset.EndDate)
 			if err != nil {
@@ -146,15 +257,21 @@ func FetchAssets() []*common.Asset {
 			}
 			now := time.Now()
 			age := now.Sub(endDate)
-			if age < (time.Hour * 24 * 7) {
+			if age < config.EndDateTolerance {
 				myAsset.DelistingDate = ""
 			}
 		}
 
-		if myAsset.DelistingDate == "" {
-			commonAssets = append(commonAssets, myAsset)
+		if myAsset.DelistingDate != "" {
+			report.Delisted++
+			continue
 		}
+
+		commonAssets = append(commonAssets, myAsset)
 	}
 
-	return commonAssets
+	report.Kept = len(commonAssets)
+	report.Log()
+
+	return commonAssets, report
 }
@@ -0,0 +1,151 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/penny-vault/import-tickers/common"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+const (
+	// ActionReject leaves assets with malformed identifiers out of the save
+	ActionReject = "reject"
+
+	// ActionQuarantine keeps the previously saved version of assets with
+	// malformed identifiers and saves them with their problems to
+	// validate.quarantine_file for review
+	ActionQuarantine = "quarantine"
+)
+
+func init() {
+	common.RegisterEnricher(&Enricher{})
+}
+
+// Quarantined is an asset held back because of malformed identifiers
+type Quarantined struct {
+	Ticker   string        `json:"ticker"`
+	Problems []*Problem    `json:"problems"`
+	Asset    *common.Asset `json:"asset"`
+}
+
+// Reason joins the problems of the asset into a single string
+func (item *Quarantined) Reason() string {
+	reasons := make([]string, len(item.Problems))
+	for ii, problem := range item.Problems {
+		reasons[ii] = problem.String()
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// Enricher is the pipeline stage that holds assets with malformed
+// identifiers back from the save. Held assets are never delisted; what
+// happens to them is set by validate.action.
+type Enricher struct {
+	held []*common.HeldAsset
+	err  error
+}
+
+func (e *Enricher) Name() string {
+	return "validate"
+}
+
+// Held returns the assets held back by the last run of the stage
+func (e *Enricher) Held() []*common.HeldAsset {
+	return e.held
+}
+
+// Err returns the error writing the quarantine file, if any
+func (e *Enricher) Err() error {
+	return e.err
+}
+
+func (e *Enricher) Enrich(ctx context.Context, assets []*common.Asset, max int) []*common.Asset {
+	e.held = nil
+	e.err = nil
+
+	action := strings.ToLower(viper.GetString("validate.action"))
+	if action == "" {
+		action = ActionQuarantine
+	}
+	if action != ActionReject && action != ActionQuarantine {
+		log.Error().Str("Action", action).Msg("unknown validate.action - quarantining assets with malformed identifiers")
+		action = ActionQuarantine
+	}
+
+	valid, quarantined := Validate(assets)
+	for _, item := range quarantined {
+		reason := item.Reason()
+		log.Warn().Str("Ticker", item.Ticker).Str("CompositeFigi", item.Asset.CompositeFigi).Str("Reason", reason).Str("Action", action).Msg("asset has malformed identifiers")
+		e.held = append(e.held, &common.HeldAsset{
+			Asset:  item.Asset,
+			Reason: reason,
+			Keep:   action == ActionQuarantine,
+		})
+	}
+	log.Info().Int("NumValid", len(valid)).Int("NumHeld", len(quarantined)).Str("Action", action).Msg("validated asset identifiers")
+
+	fn := viper.GetString("validate.quarantine_file")
+	if action == ActionQuarantine && fn != "" && !viper.GetBool("dry_run") {
+		if err := SaveQuarantine(quarantined, fn); err != nil {
+			e.err = err
+		}
+	}
+
+	return valid
+}
+
+// Validate splits `assets` into those whose identifiers are well formed and
+// those with at least one malformed identifier
+func Validate(assets []*common.Asset) ([]*common.Asset, []*Quarantined) {
+	valid := make([]*common.Asset, 0, len(assets))
+	quarantined := make([]*Quarantined, 0)
+	for _, asset := range assets {
+		problems := CheckAsset(asset)
+		if len(problems) == 0 {
+			valid = append(valid, asset)
+			continue
+		}
+		quarantined = append(quarantined, &Quarantined{
+			Ticker:   asset.Ticker,
+			Problems: problems,
+			Asset:    asset,
+		})
+	}
+	return valid, quarantined
+}
+
+// SaveQuarantine writes the quarantined assets of this run as JSON to the
+// file `fn`, replacing the previous run's file
+func SaveQuarantine(quarantined []*Quarantined, fn string) error {
+	data, err := json.MarshalIndent(quarantined, "", "  ")
+	if err != nil {
+		log.Error().Err(err).Msg("could not marshal quarantined assets to JSON")
+		return err
+	}
+
+	if err := os.WriteFile(fn, data, 0644); err != nil {
+		log.Error().Err(err).Str("FileName", fn).Msg("could not write quarantined assets")
+		return err
+	}
+
+	log.Info().Str("FileName", fn).Int("NumAssets", len(quarantined)).Msg("wrote quarantined assets")
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/penny-vault/import-tickers/common"
+	"github.com/spf13/viper"
+)
+
+func stageAssets() []*common.Asset {
+	return []*common.Asset{
+		{Ticker: "AAPL", CompositeFigi: "BBG000B9XRY4"},
+		{Ticker: "BAD", CompositeFigi: "BBG000B9XRY5"},
+	}
+}
+
+func TestEnricherQuarantine(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "quarantine.json")
+	viper.Set("validate.action", ActionQuarantine)
+	viper.Set("validate.quarantine_file", fn)
+	defer viper.Reset()
+
+	stage := &Enricher{}
+	valid := stage.Enrich(context.Background(), stageAssets(), 0)
+	if len(valid) != 1 || valid[0].Ticker != "AAPL" {
+		t.Fatalf("valid assets = %v, want AAPL", valid)
+	}
+
+	held := stage.Held()
+	if len(held) != 1 || held[0].Asset.Ticker != "BAD" || !held[0].Keep || held[0].Reason == "" {
+		t.Fatalf("held = %+v, want BAD kept with a reason", held)
+	}
+	if stage.Err() != nil {
+		t.Fatalf("Err() = %v", stage.Err())
+	}
+	if _, err := os.Stat(fn); err != nil {
+		t.Errorf("quarantine file not written: %v", err)
+	}
+}
+
+func TestEnricherReject(t *testing.T) {
+	viper.Set("validate.action", ActionReject)
+	viper.Set("validate.quarantine_file", "")
+	defer viper.Reset()
+
+	stage := &Enricher{}
+	stage.Enrich(context.Background(), stageAssets(), 0)
+	held := stage.Held()
+	if len(held) != 1 || held[0].Keep {
+		t.Fatalf("held = %+v, want BAD dropped", held)
+	}
+}
+
+func TestEnricherQuarantineWriteFails(t *testing.T) {
+	viper.Set("validate.action", ActionQuarantine)
+	viper.Set("validate.quarantine_file", filepath.Join(t.TempDir(), "missing", "quarantine.json"))
+	defer viper.Reset()
+
+	stage := &Enricher{}
+	stage.Enrich(context.Background(), stageAssets(), 0)
+	if stage.Err() == nil {
+		t.Error("Err() = nil, want error writing quarantine file")
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package validate checks the identifiers carried by assets. FIGI, CUSIP and
+// ISIN values must have a valid check digit and CIK values must be a number
+// of at most 10 digits.
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/penny-vault/import-tickers/common"
+)
+
+var (
+	ErrLength     = errors.New("wrong length")
+	ErrCharacter  = errors.New("invalid character")
+	ErrCheckDigit = errors.New("check digit mismatch")
+)
+
+// invalidFigiPrefixes are country codes that can't start a FIGI so FIGIs
+// aren't confused with ISINs
+var invalidFigiPrefixes = []string{"BS", "BM", "GG", "GB", "GH", "KY", "VG"}
+
+// Problem is a malformed identifier found on an asset
+type Problem struct {
+	Field  string `json:"field"`
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+}
+
+func (problem *Problem) String() string {
+	return fmt.Sprintf("%s '%s': %s", problem.Field, problem.Value, problem.Reason)
+}
+
+// charValue converts 0-9 and A-Z to 0-35; other characters return -1
+func charValue(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'A' && c <= 'Z':
+		return int(c-'A') + 10
+	default:
+		return -1
+	}
+}
+
+// sumDigits adds the decimal digits of `n`
+func sumDigits(n int) int {
+	sum := 0
+	for ; n > 0; n /= 10 {
+		sum += n % 10
+	}
+	return sum
+}
+
+// checkDigit returns the check digit shared by FIGI and CUSIP: the value of
+// every second character is doubled and the digits of all values are summed
+func checkDigit(values []int) int {
+	sum := 0
+	for ii, value := range values {
+		if ii%2 == 1 {
+			value *= 2
+		}
+		sum += sumDigits(value)
+	}
+	return (10 - sum%10) % 10
+}
+
+// FIGI validates a Financial Instrument Global Identifier: 12 upper-case
+// consonants and digits, 'G' in the third position, and a check digit
+func FIGI(figi string) error {
+	if len(figi) != 12 {
+		return ErrLength
+	}
+	for _, prefix := range invalidFigiPrefixes {
+		if strings.HasPrefix(figi, prefix) {
+			return fmt.Errorf("%w: prefix %s is reserved", ErrCharacter, prefix)
+		}
+	}
+	if figi[2] != 'G' {
+		return fmt.Errorf("%w: third character must be G", ErrCharacter)
+	}
+
+	values := make([]int, 11)
+	for ii := 0; ii < 11; ii++ {
+		c := figi[ii]
+		values[ii] = charValue(c)
+		if values[ii] < 0 || strings.IndexByte("AEIOU", c) >= 0 {
+			return fmt.Errorf("%w: '%c'", ErrCharacter, c)
+		}
+	}
+
+	if int(figi[11]-'0') != checkDigit(values) {
+		return ErrCheckDigit
+	}
+	return nil
+}
+
+// CUSIP validates a 9 character CUSIP including its check digit
+func CUSIP(cusip string) error {
+	if len(cusip) != 9 {
+		return ErrLength
+	}
+
+	values := make([]int, 8)
+	for ii := 0; ii < 8; ii++ {
+		c := cusip[ii]
+		switch c {
+		case '*':
+			values[ii] = 36
+		case '@':
+			values[ii] = 37
+		case '#':
+			values[ii] = 38
+		default:
+			values[ii] = charValue(c)
+		}
+		if values[ii] < 0 {
+			return fmt.Errorf("%w: '%c'", ErrCharacter, c)
+		}
+	}
+
+	if int(cusip[8]-'0') != checkDigit(values) {
+		return ErrCheckDigit
+	}
+	return nil
+}
+
+// ISIN validates a 12 character ISIN: a two letter country code, nine
+// alphanumeric characters and a Luhn check digit
+func ISIN(isin string) error {
+	if len(isin) != 12 {
+		return ErrLength
+	}
+	for ii := 0; ii < 2; ii++ {
+		if isin[ii] < 'A' || isin[ii] > 'Z' {
+			return fmt.Errorf("%w: country code '%s'", ErrCharacter, isin[:2])
+		}
+	}
+
+	// letters expand to two digits before the Luhn check
+	digits := make([]int, 0, 22)
+	for ii := 0; ii < 11; ii++ {
+		value := charValue(isin[ii])
+		if value < 0 {
+			return fmt.Errorf("%w: '%c'", ErrCharacter, isin[ii])
+		}
+		if value >= 10 {
+			digits = append(digits, value/10)
+		}
+		digits = append(digits, value%10)
+	}
+
+	// the digit next to the check digit is doubled first
+	sum := 0
+	for ii := len(digits) - 1; ii >= 0; ii-- {
+		value := digits[ii]
+		if (len(digits)-1-ii)%2 == 0 {
+			value *= 2
+		}
+		sum += sumDigits(value)
+	}
+
+	if int(isin[11]-'0') != (10-sum%10)%10 {
+		return ErrCheckDigit
+	}
+	return nil
+}
+
+// CIK validates an SEC Central Index Key: a positive number of at most 10
+// digits, optionally zero padded
+func CIK(cik string) error {
+	if len(cik) == 0 || len(cik) > 10 {
+		return ErrLength
+	}
+	nonZero := false
+	for ii := 0; ii < len(cik); ii++ {
+		if cik[ii] < '0' || cik[ii] > '9' {
+			return fmt.Errorf("%w: '%c'", ErrCharacter, cik[ii])
+		}
+		nonZero = nonZero || cik[ii] != '0'
+	}
+	if !nonZero {
+		return fmt.Errorf("%w: must not be zero", ErrCharacter)
+	}
+	return nil
+}
+
+// CheckAsset returns a problem for each malformed identifier of `asset`.
+// Identifiers that aren't set or hold the UNKNOWN placeholder are not
+// checked.
+func CheckAsset(asset *common.Asset) []*Problem {
+	checks := []struct {
+		field string
+		value string
+		check func(string) error
+	}{
+		{"CompositeFigi", asset.CompositeFigi, FIGI},
+		{"ShareClassFigi", asset.ShareClassFigi, FIGI},
+		{"CUSIP", asset.CUSIP, CUSIP},
+		{"ISIN", asset.ISIN, ISIN},
+		{"CIK", asset.CIK, CIK},
+	}
+
+	problems := make([]*Problem, 0)
+	for _, check := range checks {
+		if check.value == "" || check.value == common.UnknownFigi {
+			continue
+		}
+		if err := check.check(check.value); err != nil {
+			problems = append(problems, &Problem{
+				Field:  check.field,
+				Value:  check.value,
+				Reason: err.Error(),
+			})
+		}
+	}
+	return problems
+}
@@ -0,0 +1,138 @@
+// Copyright 2022
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/penny-vault/import-tickers/common"
+)
+
+func TestFIGI(t *testing.T) {
+	tests := []struct {
+		figi string
+		err  error
+	}{
+		{"BBG000B9XRY4", nil}, // Apple composite
+		{"BBG001S5N8V8", nil}, // Apple share class
+		{"BBG000BLNNH6", nil},
+		{"BBG000B9Y5X2", nil},
+		{"BBG000B9XRY5", ErrCheckDigit},
+		{"BBG000B9XRY", ErrLength},
+		{"BBG000B9XRY44", ErrLength},
+		{"BBGA00B9XRY4", ErrCharacter},
+		{"BBX000B9XRY4", ErrCharacter},
+		{"GBG000B9XRY4", ErrCharacter},
+		{"bbg000b9xry4", ErrCharacter},
+		{"UNKNOWN", ErrLength},
+	}
+
+	for _, tt := range tests {
+		if err := FIGI(tt.figi); !errors.Is(err, tt.err) {
+			t.Errorf("FIGI(%q) = %v, want %v", tt.figi, err, tt.err)
+		}
+	}
+}
+
+func TestCUSIP(t *testing.T) {
+	tests := []struct {
+		cusip string
+		err   error
+	}{
+		{"037833100", nil}, // Apple
+		{"594918104", nil}, // Microsoft
+		{"38259P508", nil}, // Google
+		{"037833101", ErrCheckDigit},
+		{"03783310", ErrLength},
+		{"0378331000", ErrLength},
+		{"03783-100", ErrCharacter},
+	}
+
+	for _, tt := range tests {
+		if err := CUSIP(tt.cusip); !errors.Is(err, tt.err) {
+			t.Errorf("CUSIP(%q) = %v, want %v", tt.cusip, err, tt.err)
+		}
+	}
+}
+
+func TestISIN(t *testing.T) {
+	tests := []struct {
+		isin string
+		err  error
+	}{
+		{"US0378331005", nil}, // Apple
+		{"US5949181045", nil}, // Microsoft
+		{"US38259P5089", nil}, // Google
+		{"GB0002634946", nil}, // BAE Systems
+		{"AU0000XVGZA3", nil},
+		{"US0378331006", ErrCheckDigit},
+		{"US037833100", ErrLength},
+		{"1S0378331005", ErrCharacter},
+		{"US03783310-5", ErrCharacter},
+	}
+
+	for _, tt := range tests {
+		if err := ISIN(tt.isin); !errors.Is(err, tt.err) {
+			t.Errorf("ISIN(%q) = %v, want %v", tt.isin, err, tt.err)
+		}
+	}
+}
+
+func TestCIK(t *testing.T) {
+	tests := []struct {
+		cik string
+		err error
+	}{
+		{"0000320193", nil},
+		{"320193", nil},
+		{"1", nil},
+		{"", ErrLength},
+		{"12345678901", ErrLength},
+		{"0000000000", ErrCharacter},
+		{"32019A", ErrCharacter},
+	}
+
+	for _, tt := range tests {
+		if err := CIK(tt.cik); !errors.Is(err, tt.err) {
+			t.Errorf("CIK(%q) = %v, want %v", tt.cik, err, tt.err)
+		}
+	}
+}
+
+func TestCheckAsset(t *testing.T) {
+	valid := &common.Asset{
+		Ticker:         "AAPL",
+		CompositeFigi:  "BBG000B9XRY4",
+		ShareClassFigi: "BBG001S5N8V8",
+		CUSIP:          "037833100",
+		ISIN:           "US0378331005",
+		CIK:            "0000320193",
+	}
+	if problems := CheckAsset(valid); len(problems) != 0 {
+		t.Errorf("CheckAsset(valid) = %v, want no problems", problems)
+	}
+
+	unset := &common.Asset{Ticker: "NEW", CompositeFigi: common.UnknownFigi}
+	if problems := CheckAsset(unset); len(problems) != 0 {
+		t.Errorf("CheckAsset(unset) = %v, want no problems", problems)
+	}
+
+	malformed := &common.Asset{Ticker: "BAD", CompositeFigi: "BBG000B9XRY5", CUSIP: "037833101"}
+	problems := CheckAsset(malformed)
+	if len(problems) != 2 || problems[0].Field != "CompositeFigi" || problems[1].Field != "CUSIP" {
+		t.Errorf("CheckAsset(malformed) = %v, want CompositeFigi and CUSIP problems", problems)
+	}
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2022
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package yfinance
+
+import (
+	"context"
+
+	"github.com/penny-vault/import-tickers/common"
+)
+
+func init() {
+	common.RegisterEnricher(&Enricher{})
+}
+
+// Enricher fills name, description, industry and sector from Yahoo! Finance
+type Enricher struct{}
+
+func (e *Enricher) Name() string {
+	return "yfinance"
+}
+
+func (e *Enricher) Enrich(ctx context.Context, assets []*common.Asset, max int) []*common.Asset {
+	Enrich(ctx, assets, max)
+	return assets
+}
@@ -20,22 +20,29 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/penny-vault/import-tickers/common"
 	"github.com/rs/zerolog/log"
-	"github.com/schollz/progressbar/v3"
 	"github.com/spf13/viper"
 	"golang.org/x/time/rate"
 )
 
-var kUrls []string = []string{
-	"https://query1.finance.yahoo.com/v10/finance/quoteSummary/%s?modules=assetProfile%%2CfundProfile%%2Cprice%%2CesgScores&ssl=true",
-	"https://query2.finance.yahoo.com/v10/finance/quoteSummary/%s?modules=assetProfile%%2CfundProfile%%2Cprice%%2CesgScores&ssl=true",
+const (
+	quoteSummaryPath = "/v10/finance/quoteSummary/%s?modules=assetProfile%%2CfundProfile%%2Cprice%%2CesgScores&ssl=true"
+)
+
+// DefaultBaseUrls are the Yahoo! Finance hosts requests are spread across
+var DefaultBaseUrls []string = []string{
+	"https://query1.finance.yahoo.com",
+	"https://query2.finance.yahoo.com",
 }
 
+var httpClient *http.Client
+
 // NOTE: These are sparse structs, only exctracting the information we need
 
 type YFinanceResult struct {
@@ -68,124 +75,118 @@ type YFinanceESG struct {
 	PeerGroup string `json:"peerGroup"`
 }
 
+// SetHTTPClient sets the http client used for all requests made to Yahoo!
+// Finance. Passing nil restores the default client.
+func SetHTTPClient(client *http.Client) {
+	httpClient = client
+}
+
+func newClient() *resty.Client {
+	client := resty.New()
+	if httpClient != nil {
+		client = resty.NewWithClient(httpClient)
+	}
+	return common.NewRetryPolicy("yahoo").Apply(client)
+}
+
+// baseUrls returns the Yahoo! Finance hosts, which may be overridden with
+// the yahoo.base_urls config setting
+func baseUrls() []string {
+	urls := viper.GetStringSlice("yahoo.base_urls")
+	if len(urls) == 0 {
+		return DefaultBaseUrls
+	}
+	for ii, url := range urls {
+		urls[ii] = strings.TrimSuffix(url, "/")
+	}
+	return urls
+}
+
 func RateLimit() *rate.Limiter {
 	dur := time.Duration(int64(time.Second) * 60 / viper.GetInt64("yahoo.rate_limit"))
 	yahooRate := rate.Every(dur)
 	return rate.NewLimiter(yahooRate, 2)
 }
 
+// NeedsUpdate returns true if the asset is missing data available from
+// Yahoo! Finance
+func NeedsUpdate(asset *common.Asset) bool {
+	if asset.DelistingDate != "" {
+		return false
+	}
+	switch asset.AssetType {
+	case common.CommonStock:
+		return asset.Industry == "" || asset.Sector == "" || asset.Description == ""
+	case common.MutualFund:
+		return asset.Name == ""
+	case common.ETF:
+		return asset.Description == ""
+	default:
+		return false
+	}
+}
+
 func NumAssetsNeedingUpdate(assets []*common.Asset) int {
 	totalCount := 0
 	for _, asset := range assets {
-		if asset.DelistingDate == "" && asset.AssetType == common.CommonStock && (asset.Industry == "" || asset.Sector == "" || asset.Description == "") {
-			totalCount += 1
-		}
-		if asset.DelistingDate == "" && asset.AssetType == common.MutualFund && asset.Name == "" {
-			totalCount += 1
-		}
-		if asset.DelistingDate == "" && asset.AssetType == common.ETF && asset.Description == "" {
+		if NeedsUpdate(asset) {
 			totalCount += 1
 		}
 	}
 	return totalCount
 }
 
-func Enrich(assets []*common.Asset, max int) {
-	yahooRateLimiter := RateLimit()
-
-	numNeedingUpdate := NumAssetsNeedingUpdate(assets)
-	log.Info().Int("NeedsUpdate", numNeedingUpdate).Msg("num assets needing meta-data update from yahoo")
-	if numNeedingUpdate > max && max != 0 {
-		numNeedingUpdate = max
-	}
-
-	var bar *progressbar.ProgressBar
-	if !viper.GetBool("display.hide_progress") {
-		bar = progressbar.Default(int64(numNeedingUpdate))
-	}
-
-	count := make(chan int, len(assets))
-	callCount := 0
-
+// Enrich downloads profiles for at most `max` assets (0 means no limit) that
+// need them. Lookups run concurrently using yahoo.workers workers.
+func Enrich(ctx context.Context, assets []*common.Asset, max int) {
+	pending := make([]*common.Asset, 0)
 	for _, asset := range assets {
-		if asset.DelistingDate == "" && asset.AssetType == common.CommonStock && (asset.Industry == "" || asset.Sector == "" || asset.Description == "") {
-			if !viper.GetBool("display.hide_progress") {
-				bar.Add(1)
-			}
-			yahooRateLimiter.Wait(context.Background())
-			callCount += 1
-			go func(myAsset *common.Asset) {
-				Download(myAsset)
-				count <- 1
-			}(asset)
-		}
-		if asset.DelistingDate == "" && asset.AssetType == common.MutualFund && asset.Name == "" {
-			if !viper.GetBool("display.hide_progress") {
-				bar.Add(1)
-			}
-			yahooRateLimiter.Wait(context.Background())
-			callCount += 1
-			go func(myAsset *common.Asset) {
-				Download(myAsset)
-				count <- 1
-			}(asset)
-		}
-		if asset.DelistingDate == "" && asset.AssetType == common.ETF && asset.Description == "" {
-			if !viper.GetBool("display.hide_progress") {
-				bar.Add(1)
-			}
-			yahooRateLimiter.Wait(context.Background())
-			callCount += 1
-			go func(myAsset *common.Asset) {
-				Download(myAsset)
-				count <- 1
-			}(asset)
-		}
-
-		if max > 0 && callCount > max {
-			break
+		if NeedsUpdate(asset) {
+			pending = append(pending, asset)
 		}
 	}
 
-	// wait for all items to finish
-	for callCount > 0 {
-		v := <-count
-		callCount -= v
+	log.Info().Int("NeedsUpdate", len(pending)).Msg("num assets needing meta-data update from yahoo")
+	if max > 0 && len(pending) > max {
+		pending = pending[:max]
 	}
 
+	pool := common.NewWorkerPool("yahoo", RateLimit())
+	common.RunPool(ctx, pool, pending, download)
 }
 
 // Download retrieves data for the list of assets from Yahoo! Finance
 func Download(asset *common.Asset) {
-	n := rand.Intn(len(kUrls))
+	download(context.Background(), asset)
+}
+
+func download(ctx context.Context, asset *common.Asset) error {
+	hosts := baseUrls()
+	n := rand.Intn(len(hosts))
 	ticker := strings.ReplaceAll(asset.Ticker, "/", "-")
-	url := fmt.Sprintf(kUrls[n], ticker)
+	url := fmt.Sprintf(hosts[n]+quoteSummaryPath, ticker)
 
 	subLog := log.With().Str("Url", url).Str("Source", "yfinance").Logger()
 
-	client := resty.New()
-	resp, err := client.R().Get(url)
+	client := newClient()
+	resp, err := client.R().SetContext(ctx).Get(url)
 
 	if err != nil {
 		subLog.Error().Stack().Err(err).Msg("error when fetching yahoo asset profile")
-		return
+		return err
 	}
 
 	if resp.StatusCode() >= 400 {
 		subLog.Error().Int("StatusCode", resp.StatusCode()).Msg("invalid status code received from server")
-		return
+		return fmt.Errorf("yahoo returned status code %d", resp.StatusCode())
 	}
 
 	body := resp.Body()
-	if err != nil {
-		subLog.Error().Stack().Err(err).Msg("could not read response body when fetching assets")
-		return
-	}
 
 	wrapper := YFinanceResult{}
 	if err := json.Unmarshal(body, &wrapper); err != nil {
 		subLog.Error().Stack().Err(err).Msg("could not unmarshal response body when fetching assets")
-		return
+		return err
 	}
 
 	res := wrapper.Profile.Result
@@ -196,18 +197,22 @@ func Download(asset *common.Asset) {
 		assetProfile := res[0].AssetProfile
 		if assetProfile != nil {
 			if asset.Description != assetProfile.Summary {
+				asset.RecordChange("Description", asset.Description, assetProfile.Summary, "yfinance")
 				asset.Description = assetProfile.Summary
 				asset.LastUpdated = time.Now().Unix()
 			}
 			if asset.Industry != assetProfile.Industry {
+				asset.RecordChange("Industry", asset.Industry, assetProfile.Industry, "yfinance")
 				asset.Industry = assetProfile.Industry
 				asset.LastUpdated = time.Now().Unix()
 			}
 			if asset.Sector != assetProfile.Sector {
+				asset.RecordChange("Sector", asset.Sector, assetProfile.Sector, "yfinance")
 				asset.Sector = assetProfile.Sector
 				asset.LastUpdated = time.Now().Unix()
 			}
 			if asset.CorporateUrl != assetProfile.Website {
+				asset.RecordChange("CorporateUrl", asset.CorporateUrl, assetProfile.Website, "yfinance")
 				asset.CorporateUrl = assetProfile.Website
 				asset.LastUpdated = time.Now().Unix()
 			}
@@ -215,6 +220,7 @@ func Download(asset *common.Asset) {
 		price := res[0].Price
 		if price != nil {
 			if asset.Name == "" {
+				asset.RecordChange("Name", asset.Name, price.Name, "yfinance")
 				asset.Name = price.Name
 				asset.LastUpdated = time.Now().Unix()
 			}
@@ -222,11 +228,12 @@ func Download(asset *common.Asset) {
 		esg := res[0].Esg
 		if esg != nil {
 			if asset.Description == "" {
+				asset.RecordChange("Description", asset.Description, esg.PeerGroup, "yfinance")
 				asset.Description = esg.PeerGroup
 				asset.LastUpdated = time.Now().Unix()
 			}
 		}
-
-		return
 	}
+
+	return nil
 }